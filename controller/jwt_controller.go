@@ -1,97 +1,203 @@
 package controller
 
 import (
+	"database/sql"
 	"fmt"
-	"os"
 	"sec-app-server/model"
+	"sec-app-server/utils"
 	"time"
 
-	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtKey []byte
+const AccessTokenLifetime = 15 * time.Minute
 
-func LoadJWTSecret() {
-	jwtKey = []byte(os.Getenv("JWT_SECRET"))
-}
+// the "typ" claim keeps tokens meant for one step from being used for another
+const (
+	accessTokenType     = "access"
+	mfaPendingTokenType = "mfa"
+)
+
+// Revocations is checked by DecodeJWT on every token, it can be swapped for a
+// model.MemoryRevocationStore in tests.
+var Revocations model.RevocationStore = model.PostgresRevocationStore{}
 
 type Credentials struct {
 	MailOrUsername string `json:"email"`
 	Password       string `json:"password"`
 }
 
-func EncodeJWT(mail string) (string, error) {
-	fmt.Println(mail)
-	isUserAdmin, err := model.IsUserAdmin(mail)
-	fmt.Println(isUserAdmin)
+// TokenPair is what a client receives when logging in or refreshing: a short
+// lived access JWT and the opaque refresh token used to get the next one.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// EncodeJWT opens a new session for the user
+func EncodeJWT(mail string) (*TokenPair, error) {
+	accessToken, err := signAccessToken(mail)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := model.CreateRefreshToken(mail, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(AccessTokenLifetime.Seconds()),
+	}, nil
+}
+
+// RefreshJWT rotates the refresh token and issues a fresh access token
+func RefreshJWT(refreshToken string) (*TokenPair, error) {
+	mail, newRefreshToken, err := model.RotateRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := signAccessToken(mail)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: newRefreshToken,
+		ExpiresIn:    int(AccessTokenLifetime.Seconds()),
+	}, nil
+}
+
+func signAccessToken(mail string) (string, error) {
+	user, err := model.GetUserByEmailOrUsername(mail, true)
 	if err != nil {
-		fmt.Println("bug here : ", err)
 		return "", err
 	}
 
-	var role string
+	roles, err := model.GetUserRoles(mail)
+	if err != nil {
+		fmt.Println("bug here : ", err)
+		return "", err
+	}
 
-	if isUserAdmin {
-		role = "admin"
-	} else {
-		role = "user"
+	generation, err := Revocations.TokenGeneration(mail)
+	if err != nil {
+		return "", err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"mail": mail,
-		"exp":  time.Now().Add(24 * time.Hour).Unix(),
-		"role": role,
+	now := time.Now()
+	return signToken(tokenClaims{
+		Type:       accessTokenType,
+		Mail:       mail,
+		Roles:      roles,
+		Generation: generation,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        utils.GenerateToken(16),
+			Subject:   user.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenLifetime)),
+		},
 	})
-	return token.SignedString(jwtKey)
 }
 
-func DecodeJWT(tokenString string) (*jwt.Token, bool, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method : %s", "ValidationErrorSignatureInvalid")
-		}
-		return jwtKey, nil
-	})
+// tokenClaims is the payload of every token the server signs, told apart by
+// Type. Subject is the user ID and Mail the hashed email. Generation is the
+// one of the user's tokens when it was signed, see model.RevocationStore.
+type tokenClaims struct {
+	Type       string   `json:"typ"`
+	Mail       string   `json:"mail"`
+	Roles      []string `json:"roles,omitempty"`
+	Generation int64    `json:"gen"`
+	jwt.RegisteredClaims
+}
 
+// DecodeJWT checks an access token and returns the identity it carries. The
+// only database access is the revocation check.
+func DecodeJWT(tokenString string) (*Principal, error) {
+	claims, err := parseToken(tokenString, accessTokenType)
 	if err != nil {
-		return nil, false, err
+		return nil, err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		username := claims["mail"].(string)
-		isUserAdmin, err := model.IsUserAdmin(username)
-		fmt.Println("userad", isUserAdmin, username)
-		if err != nil {
-			return nil, false, err
-		}
-		return token, isUserAdmin, nil
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("invalid token or claims")
 	}
 
-	return nil, false, fmt.Errorf("invalid token or claims")
+	return &Principal{
+		UserID:    claims.Subject,
+		Email:     claims.Mail,
+		Roles:     claims.Roles,
+		TokenID:   claims.ID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
 }
 
-func GetUserEmailFromGinContext(c *gin.Context) (string, error) {
-	tokenString := c.GetHeader("Authorization")
-	if tokenString == "" {
-		return "", fmt.Errorf("authorization header is missing")
+// parseToken checks the signature, expiry, type and revocation of a token
+func parseToken(tokenString, typ string) (*tokenClaims, error) {
+	var claims tokenClaims
+	token, err := jwt.ParseWithClaims(tokenString, &claims, verificationKey,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithExpirationRequired(), jwt.WithIssuedAt())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token or claims")
 	}
 
-	tokenString = tokenString[len("Bearer "):] // Remove "Bearer " prefix
+	if claims.Type != typ {
+		return nil, fmt.Errorf("unexpected token type")
+	}
 
-	token, isUserAdmin, err := DecodeJWT(tokenString)
+	if claims.ID == "" {
+		return nil, fmt.Errorf("token is not revocable")
+	}
+
+	revoked, err := Revocations.IsRevoked(claims.ID, claims.Mail, claims.Generation)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if revoked {
+		return nil, fmt.Errorf("token has been revoked")
 	}
 
-	if !isUserAdmin {
-		return "", fmt.Errorf("user is not an admin")
+	return &claims, nil
+}
+
+// RevokeJWT puts a single token on the denylist until it expires
+func RevokeJWT(tokenID string, expiresAt time.Time) error {
+	return Revocations.RevokeToken(tokenID, expiresAt)
+}
+
+// DeleteUser removes the account once every token issued to it is revoked,
+// so that none keeps working until it expires
+func DeleteUser(id string) error {
+	user, err := model.GetUserByID(id)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		username := claims["mail"].(string)
-		return username, nil
+	if err := RevokeAllUserTokens(user.Email); err != nil {
+		return err
 	}
+	return model.RemoveUser(id)
+}
 
-	return "", fmt.Errorf("invalid token or claims")
+// RevokeAllUserTokens invalidates every access and refresh token issued so
+// far to the user
+func RevokeAllUserTokens(mail string) error {
+	if err := model.RevokeUserRefreshTokens(mail); err != nil {
+		return err
+	}
+	return Revocations.RevokeAllForUser(mail)
 }
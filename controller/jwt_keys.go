@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrNoSigningKey = errors.New("no JWT signing key configured (JWT_PRIVATE_KEY_FILE)")
+
+// jwtKey is a key pair, or only the public half for keys kept to verify the
+// tokens signed before a rotation. ID is the "kid" header of the tokens.
+type jwtKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	Public crypto.PublicKey
+	// Private is nil for verification only keys
+	Private crypto.Signer
+}
+
+var (
+	signingKey       *jwtKey
+	verificationKeys = map[string]*jwtKey{}
+)
+
+// LoadJWTKeys reads the PEM encoded RSA (RS256) or Ed25519 (EdDSA) private
+// key tokens are signed with from JWT_PRIVATE_KEY_FILE, and from
+// JWT_PUBLIC_KEY_FILES, comma separated, the previous keys whose tokens are
+// still accepted. To rotate, move the old key to JWT_PUBLIC_KEY_FILES and
+// drop it once AccessTokenLifetime has passed.
+func LoadJWTKeys() error {
+	privatePath := os.Getenv("JWT_PRIVATE_KEY_FILE")
+	if privatePath == "" {
+		return ErrNoSigningKey
+	}
+
+	key, err := readJWTKey(privatePath)
+	if err != nil {
+		return err
+	}
+	if key.Private == nil {
+		return fmt.Errorf("%s: not a private key", privatePath)
+	}
+
+	keys := map[string]*jwtKey{key.ID: key}
+	for _, path := range strings.Split(os.Getenv("JWT_PUBLIC_KEY_FILES"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		old, err := readJWTKey(path)
+		if err != nil {
+			return err
+		}
+		// only the public half of a retired key is ever used
+		old.Private = nil
+		if _, ok := keys[old.ID]; !ok {
+			keys[old.ID] = old
+		}
+	}
+
+	signingKey = key
+	verificationKeys = keys
+	return nil
+}
+
+func readJWTKey(path string) (*jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block found", path)
+	}
+
+	var parsed any
+	switch block.Type {
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key := &jwtKey{}
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.Private = signer
+		parsed = signer.Public()
+	}
+
+	switch public := parsed.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("%s: RSA keys must be at least 2048 bits", path)
+		}
+		key.Method = jwt.SigningMethodRS256
+		key.Public = public
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.Public = public
+	default:
+		return nil, fmt.Errorf("%s: only RSA and Ed25519 keys are supported", path)
+	}
+
+	key.ID = thumbprint(key.JWK())
+	return key, nil
+}
+
+// JWK is a public key as published on /.well-known/jwks.json (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+func (k *jwtKey) JWK() JWK {
+	jwk := JWK{Use: "sig", Alg: k.Method.Alg(), Kid: k.ID}
+	switch public := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
+
+// thumbprint is the RFC 7638 thumbprint of the key, used as its kid so that
+// the same key always gets the same id without having to configure one
+func thumbprint(jwk JWK) string {
+	// the required members only, in lexicographic order
+	var members any
+	if jwk.Kty == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// JWKS returns the keys tokens are currently verified with, for the other
+// services to check them, sorted by kid
+func JWKS() []JWK {
+	keys := make([]JWK, 0, len(verificationKeys))
+	for _, key := range verificationKeys {
+		keys = append(keys, key.JWK())
+	}
+	slices.SortFunc(keys, func(a, b JWK) int { return strings.Compare(a.Kid, b.Kid) })
+	return keys
+}
+
+// signToken signs the claims with the current key, its id in the header
+func signToken(claims jwt.Claims) (string, error) {
+	if signingKey == nil {
+		return "", ErrNoSigningKey
+	}
+	token := jwt.NewWithClaims(signingKey.Method, claims)
+	token.Header["kid"] = signingKey.ID
+	return token.SignedString(signingKey.Private)
+}
+
+// verificationKey is the jwt.Keyfunc picking the key named by the kid header,
+// as long as the token was signed with the algorithm of that key
+func verificationKey(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := verificationKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method : %s", token.Method.Alg())
+	}
+	return key.Public, nil
+}
@@ -0,0 +1,254 @@
+package controller
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"sec-app-server/model"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// withJWTKeys loads the given PEM files as the signing and retired keys, and
+// puts the previous keys back once the test is done
+func withJWTKeys(t *testing.T, privatePath string, publicPaths ...string) error {
+	t.Helper()
+	previousSigning, previousVerification := signingKey, verificationKeys
+	t.Cleanup(func() { signingKey, verificationKeys = previousSigning, previousVerification })
+
+	t.Setenv("JWT_PRIVATE_KEY_FILE", privatePath)
+	t.Setenv("JWT_PUBLIC_KEY_FILES", strings.Join(publicPaths, ","))
+	return LoadJWTKeys()
+}
+
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeEd25519Key(t *testing.T) (private, public string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "ed25519.pem", "PRIVATE KEY", privDER), writePEM(t, "ed25519.pub", "PUBLIC KEY", pubDER)
+}
+
+func writeRSAKey(t *testing.T, bits int) (private, public string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+		writePEM(t, "rsa.pub", "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&key.PublicKey))
+}
+
+func TestLoadJWTKeys(t *testing.T) {
+	edPrivate, _ := writeEd25519Key(t)
+	_, rsaPublic := writeRSAKey(t, 2048)
+
+	if err := withJWTKeys(t, edPrivate, rsaPublic); err != nil {
+		t.Fatal(err)
+	}
+
+	if signingKey.Method != jwt.SigningMethodEdDSA || signingKey.Private == nil {
+		t.Errorf("signing key = %s, private %v; want an EdDSA private key", signingKey.Method.Alg(), signingKey.Private != nil)
+	}
+	if len(verificationKeys) != 2 {
+		t.Fatalf("%d verification keys, want 2", len(verificationKeys))
+	}
+	for id, key := range verificationKeys {
+		if id != key.ID {
+			t.Errorf("key %q stored under %q", key.ID, id)
+		}
+		if id != signingKey.ID && (key.Method != jwt.SigningMethodRS256 || key.Private != nil) {
+			t.Errorf("retired key = %s, private %v; want an RS256 public key", key.Method.Alg(), key.Private != nil)
+		}
+	}
+}
+
+func TestLoadJWTKeysRejects(t *testing.T) {
+	edPrivate, edPublic := writeEd25519Key(t)
+	smallRSA, _ := writeRSAKey(t, 1024)
+
+	tests := []struct {
+		name    string
+		private string
+		public  []string
+		want    string
+	}{
+		{"no signing key", "", nil, "no JWT signing key"},
+		{"public signing key", edPublic, nil, "not a private key"},
+		{"small RSA signing key", smallRSA, nil, "at least 2048 bits"},
+		{"small RSA retired key", edPrivate, []string{smallRSA}, "at least 2048 bits"},
+		{"missing file", filepath.Join(t.TempDir(), "missing.pem"), nil, "no such file"},
+		{"certificate", writePEM(t, "cert.pem", "CERTIFICATE", []byte("x")), nil, "unsupported PEM block"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := withJWTKeys(t, tt.private, tt.public...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadJWTKeys = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	tests := []struct {
+		name string
+		jwk  JWK
+		want string
+	}{
+		// RFC 7638 section 3.1
+		{"RSA", JWK{
+			Kty: "RSA",
+			N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+			E:   "AQAB",
+			// not part of the thumbprint
+			Alg: "RS256", Kid: "2011-04-29",
+		}, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"},
+		// RFC 8037 appendix A.3
+		{"Ed25519", JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo",
+		}, "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := thumbprint(tt.jwk); got != tt.want {
+				t.Errorf("thumbprint = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignedTokenVerification(t *testing.T) {
+	previousRevocations := Revocations
+	Revocations = model.NewMemoryRevocationStore()
+	t.Cleanup(func() { Revocations = previousRevocations })
+
+	rsaPrivate, _ := writeRSAKey(t, 2048)
+	if err := withJWTKeys(t, rsaPrivate); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	claims := tokenClaims{
+		Type: accessTokenType,
+		Mail: "mail",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti",
+			Subject:   "1",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	}
+
+	signed, err := signToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseToken(signed, accessTokenType); err != nil {
+		t.Errorf("parseToken of a token signed with the current key: %v", err)
+	}
+
+	// a key which is not loaded, with the kid of the loaded one or its own
+	_, otherEd25519, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(method jwt.SigningMethod, kid string, key any) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"unknown kid", sign(jwt.SigningMethodRS256, "unknown", otherRSA)},
+		{"no kid", sign(jwt.SigningMethodRS256, "", otherRSA)},
+		{"other RSA key", sign(jwt.SigningMethodRS256, signingKey.ID, otherRSA)},
+		{"EdDSA with an RSA kid", sign(jwt.SigningMethodEdDSA, signingKey.ID, otherEd25519)},
+		{"HS256 with an RSA kid", sign(jwt.SigningMethodHS256, signingKey.ID, []byte("secret"))},
+		{"alg none", sign(jwt.SigningMethodNone, signingKey.ID, jwt.UnsafeAllowNoneSignatureType)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseToken(tt.token, accessTokenType); err == nil {
+				t.Error("parseToken accepted the token")
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	edPrivate, _ := writeEd25519Key(t)
+	_, rsaPublic := writeRSAKey(t, 2048)
+	_, otherPublic := writeEd25519Key(t)
+
+	if err := withJWTKeys(t, edPrivate, rsaPublic, otherPublic); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := JWKS()
+	if len(keys) != 3 {
+		t.Fatalf("%d keys, want 3", len(keys))
+	}
+	for i, jwk := range keys {
+		if i > 0 && keys[i-1].Kid >= jwk.Kid {
+			t.Errorf("keys not sorted by kid: %q before %q", keys[i-1].Kid, jwk.Kid)
+		}
+		key, ok := verificationKeys[jwk.Kid]
+		if !ok {
+			t.Fatalf("published kid %q is not a verification key", jwk.Kid)
+		}
+		if thumbprint(jwk) != jwk.Kid {
+			t.Errorf("kid %q is not the thumbprint of the key", jwk.Kid)
+		}
+		if jwk.Alg != key.Method.Alg() {
+			t.Errorf("alg = %q, want %q", jwk.Alg, key.Method.Alg())
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		again := JWKS()
+		for j := range keys {
+			if again[j] != keys[j] {
+				t.Fatalf("JWKS order changed between calls")
+			}
+		}
+	}
+}
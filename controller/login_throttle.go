@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"fmt"
+	mailcontroller "sec-app-server/mail_controller"
+	"sec-app-server/model"
+	"sec-app-server/utils"
+	"strings"
+	"time"
+)
+
+// LoginAttempts holds the failed login counters, it can be swapped for a
+// model.MemoryLoginAttemptStore in tests.
+var LoginAttempts model.LoginAttemptStore = &model.PostgresLoginAttemptStore{}
+
+// throttlePolicy lets FreeAttempts failures go, then locks the key for
+// BaseLockout, doubled on every further failure up to MaxLockout.
+type throttlePolicy struct {
+	FreeAttempts int
+	BaseLockout  time.Duration
+	MaxLockout   time.Duration
+}
+
+var (
+	accountThrottle = throttlePolicy{FreeAttempts: 5, BaseLockout: 30 * time.Second, MaxLockout: time.Hour}
+	// an IP may legitimately front many users (NAT, proxies), hence the leeway
+	ipThrottle = throttlePolicy{FreeAttempts: 20, BaseLockout: 30 * time.Second, MaxLockout: time.Hour}
+)
+
+func (p throttlePolicy) lockout(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	lockout := p.BaseLockout
+	for i := p.FreeAttempts + 1; i < failures && lockout < p.MaxLockout; i++ {
+		lockout *= 2
+	}
+	return min(lockout, p.MaxLockout)
+}
+
+// AccountThrottleKey identifies an account for throttling. Known users are
+// keyed by their hashed email, whether they typed it or their username, and
+// unknown identifiers by their own hash.
+func AccountThrottleKey(user *model.User, identifier string) string {
+	if user != nil {
+		return "account:" + user.Email
+	}
+	return "account:" + utils.HashString(strings.ToLower(identifier))
+}
+
+// MFAThrottleKey identifies the user for throttling the second factor checks
+// made with an access token, such as enabling or disabling MFA
+func MFAThrottleKey(userID string) string {
+	return "mfa:" + userID
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// LoginRetryAfter returns how long the account or the IP is still locked
+func LoginRetryAfter(accountKey, ip string) (time.Duration, error) {
+	var wait time.Duration
+	for _, key := range []string{accountKey, ipThrottleKey(ip)} {
+		attempts, err := LoginAttempts.Get(key)
+		if err != nil {
+			return 0, err
+		}
+		wait = max(wait, time.Until(attempts.LockedUntil))
+	}
+	return wait, nil
+}
+
+// RecordLoginFailure counts a failed attempt. accountLocked is true when this
+// failure is the one that locks the account, so that its owner is warned once.
+func RecordLoginFailure(accountKey, ip string) (accountLocked bool, err error) {
+	now := time.Now()
+	record := func(policy throttlePolicy) func(*model.LoginAttempts) {
+		return func(a *model.LoginAttempts) {
+			a.Failures++
+			a.LastFailure = now
+			if lockout := policy.lockout(a.Failures); lockout > 0 {
+				a.LockedUntil = now.Add(lockout)
+			}
+		}
+	}
+
+	account, err := LoginAttempts.Update(accountKey, record(accountThrottle))
+	if err != nil {
+		return false, err
+	}
+	if _, err := LoginAttempts.Update(ipThrottleKey(ip), record(ipThrottle)); err != nil {
+		return false, err
+	}
+
+	return account.Failures == accountThrottle.FreeAttempts+1, nil
+}
+
+// RecordLoginSuccess clears the account counter. The IP one is left to expire
+// so that owning one account does not unlock guessing on the others.
+func RecordLoginSuccess(accountKey string) error {
+	return LoginAttempts.Reset(accountKey)
+}
+
+// WarnAccountLocked mails the owner of an account that just got locked, in
+// the background. typedEmail is the address used to log in, if any.
+func WarnAccountLocked(user *model.User, typedEmail string) {
+	if user == nil {
+		return
+	}
+
+	go func() {
+		email, err := model.GetContactEmail(user.ID)
+		if err != nil {
+			if utils.HashString(typedEmail) != user.Email {
+				fmt.Println("No address to warn about the account lockout:", err)
+				return
+			}
+			email = typedEmail
+		}
+
+		err = mailcontroller.SendMail(email, "Too many failed login attempts", fmt.Sprintf(
+			"Hello %s,<br>Several failed attempts to log into your account were made, so logging in has been blocked for a while. "+
+				"If this was not you, we advise you to change your password: %s/forgot-password",
+			user.Username, utils.ClientUrl,
+		))
+		if err != nil {
+			fmt.Println("Error sending lockout email:", err)
+		}
+	}()
+}
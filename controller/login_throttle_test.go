@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"sec-app-server/model"
+	"testing"
+	"time"
+)
+
+func TestThrottlePolicyLockout(t *testing.T) {
+	policy := throttlePolicy{FreeAttempts: 5, BaseLockout: 30 * time.Second, MaxLockout: 5 * time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{5, 0},
+		{6, 30 * time.Second},
+		{7, time.Minute},
+		{8, 2 * time.Minute},
+		{9, 4 * time.Minute},
+		{10, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := policy.lockout(tt.failures); got != tt.want {
+			t.Errorf("lockout(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func withMemoryLoginAttempts(t *testing.T) {
+	previous := LoginAttempts
+	LoginAttempts = model.NewMemoryLoginAttemptStore()
+	t.Cleanup(func() { LoginAttempts = previous })
+}
+
+func TestRecordLoginFailureLocksAccount(t *testing.T) {
+	withMemoryLoginAttempts(t)
+	key := "account:test"
+
+	for i := 1; i <= accountThrottle.FreeAttempts; i++ {
+		locked, err := RecordLoginFailure(key, "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked {
+			t.Fatalf("locked after %d failures", i)
+		}
+		if wait, _ := LoginRetryAfter(key, "192.0.2.1"); wait > 0 {
+			t.Fatalf("waiting %v after %d failures", wait, i)
+		}
+	}
+
+	locked, _ := RecordLoginFailure(key, "192.0.2.1")
+	if !locked {
+		t.Fatal("not reported as locked after the free attempts")
+	}
+	wait, _ := LoginRetryAfter(key, "192.0.2.2")
+	if wait <= 0 || wait > accountThrottle.BaseLockout {
+		t.Fatalf("wait = %v, want up to %v", wait, accountThrottle.BaseLockout)
+	}
+
+	// the lock is reported once, further failures lock longer
+	locked, _ = RecordLoginFailure(key, "192.0.2.1")
+	if locked {
+		t.Error("reported as locked again")
+	}
+	if longer, _ := LoginRetryAfter(key, "192.0.2.2"); longer <= wait {
+		t.Errorf("lockout did not grow: %v then %v", wait, longer)
+	}
+}
+
+func TestRecordLoginSuccessKeepsIPCounter(t *testing.T) {
+	withMemoryLoginAttempts(t)
+
+	for range ipThrottle.FreeAttempts + 1 {
+		RecordLoginFailure("account:other", "192.0.2.1")
+	}
+	if err := RecordLoginSuccess("account:other"); err != nil {
+		t.Fatal(err)
+	}
+
+	if wait, _ := LoginRetryAfter("account:fresh", "192.0.2.1"); wait <= 0 {
+		t.Error("a successful login unlocked the IP")
+	}
+	if wait, _ := LoginRetryAfter("account:other", "192.0.2.2"); wait > 0 {
+		t.Errorf("account still locked for %v after a successful login", wait)
+	}
+}
@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"sec-app-server/model"
+	"sec-app-server/utils"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MFAPendingTokenLifetime is the time left to enter the code once the
+// password has been checked
+const MFAPendingTokenLifetime = 5 * time.Minute
+
+var MFAConfig struct {
+	Issuer string
+	// RequiredForAdmins denies admin routes to admins who did not enroll
+	RequiredForAdmins bool
+}
+
+func LoadMFAConfig() {
+	MFAConfig.Issuer = os.Getenv("MFA_ISSUER")
+	if MFAConfig.Issuer == "" {
+		MFAConfig.Issuer = "MyWeed"
+	}
+	MFAConfig.RequiredForAdmins = os.Getenv("MFA_REQUIRED_FOR_ADMINS") == "true"
+}
+
+// EncodeMFAPendingJWT returns the token proving the password step of the
+// login succeeded. It is only accepted by /login/mfa.
+func EncodeMFAPendingJWT(user *model.User) (string, error) {
+	generation, err := Revocations.TokenGeneration(user.Email)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return signToken(tokenClaims{
+		Type:       mfaPendingTokenType,
+		Mail:       user.Email,
+		Generation: generation,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        utils.GenerateToken(16),
+			Subject:   user.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(MFAPendingTokenLifetime)),
+		},
+	})
+}
+
+// DecodeMFAPendingJWT checks a token from EncodeMFAPendingJWT. The returned
+// Principal has not passed the second factor yet and carries no role.
+func DecodeMFAPendingJWT(tokenString string) (*Principal, error) {
+	claims, err := parseToken(tokenString, mfaPendingTokenType)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Subject == "" || claims.Mail == "" {
+		return nil, fmt.Errorf("invalid token or claims")
+	}
+	return &Principal{
+		UserID:    claims.Subject,
+		Email:     claims.Mail,
+		TokenID:   claims.ID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
+
+// IsMFAEnrollmentRequired tells whether the user has to enroll before being
+// let into the staff routes
+func IsMFAEnrollmentRequired(userID string, isStaff bool) (bool, error) {
+	if !MFAConfig.RequiredForAdmins || !isStaff {
+		return false, nil
+	}
+
+	enabled, err := model.IsMFAEnabled(userID)
+	if err != nil {
+		return false, err
+	}
+	return !enabled, nil
+}
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"fmt"
+	mailcontroller "sec-app-server/mail_controller"
+	"sec-app-server/model"
+	"sec-app-server/utils"
+)
+
+var orderStatusSubjects = map[string]string{
+	model.OrderPaid:      "Payment received",
+	model.OrderFailed:    "Your payment did not go through",
+	model.OrderPreparing: "Your order is being prepared",
+	model.OrderShipped:   "Your order has been shipped",
+	model.OrderDelivered: "Your order has been delivered",
+	model.OrderCancelled: "Your order has been cancelled",
+	model.OrderRefunded:  "Your order has been refunded",
+}
+
+// NotifyOrderStatus mails the customer about a status change of their order,
+// in the background
+func NotifyOrderStatus(change *model.OrderStatusChange) {
+	if change.CustomerID == "" {
+		return
+	}
+
+	go func() {
+		user, err := model.GetUserByID(change.CustomerID)
+		if err != nil {
+			fmt.Println("Error fetching customer to notify:", err)
+			return
+		}
+
+		email, err := model.GetContactEmail(user.ID)
+		if err != nil {
+			fmt.Println("No address to notify about the order status:", err)
+			return
+		}
+
+		subject, ok := orderStatusSubjects[change.To]
+		if !ok {
+			subject = "Your order has been updated"
+		}
+
+		err = mailcontroller.SendTemplate(email, fmt.Sprintf("%s (%s)", subject, change.Numero), "order_status.html", map[string]any{
+			"Username":  user.Username,
+			"Numero":    change.Numero,
+			"Status":    change.To,
+			"Note":      change.Note,
+			"OrdersURL": utils.ClientUrl + "/orders",
+		})
+		if err != nil {
+			fmt.Println("Error sending order status email:", err)
+		}
+	}()
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sec-app-server/model"
+	"sec-app-server/payments"
+)
+
+var ErrPaymentProviderChanged = errors.New("order was paid with another payment provider")
+
+// StartOrderPayment creates a payment intent of an order with the current
+// provider, the one the order is paid with until a retry replaces it.
+// Concurrent attempts get the same intent from the provider.
+func StartOrderPayment(ctx context.Context, order *model.Order) (*payments.Intent, error) {
+	attempt, err := model.CountOrderPaymentIntents(order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("intent:order:%s:%d", order.ID, attempt)
+	intent, err := payments.Current.CreateIntent(ctx, order.Numero, order.Price, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := model.SetOrderPaymentIntent(order.ID, payments.Current.Name(), intent.ID, intent.Amount); err != nil {
+		return nil, err
+	}
+	return intent, nil
+}
+
+// RefundOrderPayment refunds the payment of an order in full. Orders that
+// were never paid through a provider, or already refunded, are left alone.
+// The refund is claimed first, so that concurrent requests return
+// model.ErrRefundInProgress instead of refunding twice.
+func RefundOrderPayment(ctx context.Context, order *model.OrderDetail) error {
+	if order.Payment == nil || order.Payment.RefundID != "" {
+		return nil
+	}
+	if order.Payment.Provider != payments.Current.Name() {
+		return ErrPaymentProviderChanged
+	}
+
+	claimed, err := model.ClaimOrderRefund(order.ID)
+	if err != nil || !claimed {
+		return err
+	}
+
+	refundID, err := payments.Current.Refund(ctx, order.Payment.IntentID, order.Price, refundIdempotencyKey(order.ID))
+	if err != nil {
+		model.ReleaseOrderRefund(order.ID)
+		return err
+	}
+	return model.SetOrderRefund(order.ID, refundID)
+}
+
+// an order is refunded in full once, whatever the number of attempts
+func refundIdempotencyKey(orderID string) string {
+	return "refund:order:" + orderID
+}
+
+// HandlePaymentWebhook verifies a webhook of the provider and applies it to
+// the order it is about. The change is nil when the event changed nothing.
+// A payment the order could not take is refunded, an error leaving the
+// refund to the next delivery of the webhook.
+func HandlePaymentWebhook(ctx context.Context, payload []byte, header map[string][]string) (*model.OrderStatusChange, error) {
+	event, err := payments.Current.VerifyWebhook(payload, header)
+	if err != nil {
+		return nil, err
+	}
+
+	var status string
+	switch event.Type {
+	case payments.EventPaymentSucceeded:
+		status = model.OrderPaid
+	case payments.EventPaymentFailed:
+		status = model.OrderFailed
+	default:
+		return nil, nil
+	}
+
+	change, err := model.ApplyPaymentEvent(payments.Current.Name(), event.ID, event.IntentID, status)
+	if errors.Is(err, model.ErrOrderNotFound) {
+		// nothing to retry, the provider can stop sending it
+		fmt.Println("Payment event for an unknown intent:", event.ID, event.IntentID)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if status == model.OrderPaid {
+		if err := refundStrayPayment(ctx, event.IntentID); err != nil {
+			return nil, err
+		}
+	}
+	return change, nil
+}
+
+// refundStrayPayment gives back the money of the intent when the order it
+// was for could not take it
+func refundStrayPayment(ctx context.Context, intentID string) error {
+	stray, err := model.GetStrayPayment(payments.Current.Name(), intentID)
+	if err != nil || stray == nil {
+		return err
+	}
+
+	refundID, err := payments.Current.Refund(ctx, stray.IntentID, stray.Amount, "refund:intent:"+stray.IntentID)
+	if err != nil {
+		fmt.Println("Error refunding stray payment of order", stray.OrderID, ":", err)
+		return err
+	}
+	return model.SetStrayPaymentRefund(payments.Current.Name(), stray.IntentID, refundID)
+}
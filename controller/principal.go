@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"fmt"
+	"sec-app-server/model"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Principal is the authenticated caller of a request, as read once from its
+// access token by middlewares.Authenticate.
+type Principal struct {
+	UserID string
+	// Email is the hashed email, the key most of the model works with
+	Email     string
+	Roles     []string
+	TokenID   string
+	ExpiresAt time.Time
+}
+
+const (
+	principalKey = "principal"
+	authErrorKey = "auth_error"
+)
+
+// IsStaff tells whether the caller holds any role
+func (p *Principal) IsStaff() bool {
+	return len(p.Roles) > 0
+}
+
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// AuthenticateRequest decodes the bearer token of the request, if any, and
+// stores the result in the context for GetPrincipal and GetAuthError.
+func AuthenticateRequest(c *gin.Context) {
+	header := c.GetHeader("Authorization")
+	if header == "" {
+		return
+	}
+
+	tokenString, found := strings.CutPrefix(header, "Bearer ")
+	if !found || tokenString == "" {
+		c.Set(authErrorKey, fmt.Errorf("malformed authorization header"))
+		return
+	}
+
+	principal, err := DecodeJWT(tokenString)
+	if err != nil {
+		c.Set(authErrorKey, err)
+		return
+	}
+	c.Set(principalKey, principal)
+}
+
+// GetPrincipal returns the authenticated caller, ok is false for anonymous
+// requests and requests with an invalid token
+func GetPrincipal(c *gin.Context) (*Principal, bool) {
+	value, exists := c.Get(principalKey)
+	if !exists {
+		return nil, false
+	}
+	principal, ok := value.(*Principal)
+	return principal, ok
+}
+
+// MustGetPrincipal is GetPrincipal for handlers behind Authenticated, where
+// the caller is always known. It panics otherwise.
+func MustGetPrincipal(c *gin.Context) *Principal {
+	principal, ok := GetPrincipal(c)
+	if !ok {
+		panic("request is not authenticated")
+	}
+	return principal
+}
+
+// GetAuthError returns why the token sent with the request was refused, nil
+// when it was accepted or there was none
+func GetAuthError(c *gin.Context) error {
+	value, exists := c.Get(authErrorKey)
+	if !exists {
+		return nil
+	}
+	err, _ := value.(error)
+	return err
+}
+
+// GetCurrentUser loads the authenticated caller from the database, for the
+// handlers that need more than the Principal holds
+func GetCurrentUser(c *gin.Context) (*model.User, error) {
+	principal, ok := GetPrincipal(c)
+	if !ok {
+		return nil, fmt.Errorf("request is not authenticated")
+	}
+	return model.GetUserByID(principal.UserID)
+}
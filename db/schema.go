@@ -0,0 +1,422 @@
+package db
+
+import "fmt"
+
+// schema holds the idempotent statements applied at startup on top of the
+// base tables, in order.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti        TEXT PRIMARY KEY,
+		expires_at TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_token_revocations (
+		user_email TEXT PRIMARY KEY,
+		generation BIGINT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS refresh_tokens (
+		token_hash TEXT PRIMARY KEY,
+		family_id  TEXT NOT NULL,
+		user_email TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		used_at    TIMESTAMPTZ,
+		revoked_at TIMESTAMPTZ
+	)`,
+	`CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family_id)`,
+	`CREATE INDEX IF NOT EXISTS refresh_tokens_user_idx ON refresh_tokens (user_email)`,
+	// argon2id encoded hashes are longer than the legacy sha256 digests
+	`ALTER TABLE users ALTER COLUMN password TYPE TEXT`,
+	`CREATE TABLE IF NOT EXISTS password_reset_tokens (
+		token_hash TEXT PRIMARY KEY,
+		user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		expires_at TIMESTAMPTZ NOT NULL,
+		used_at    TIMESTAMPTZ
+	)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS verification_expires_at TIMESTAMPTZ`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS verification_sent_at TIMESTAMPTZ`,
+	// tokens sent before expiry existed get a fresh validity window
+	`UPDATE users SET verification_expires_at = NOW() + INTERVAL '24 hours'
+		WHERE verification_token IS NOT NULL AND verification_expires_at IS NULL`,
+	// prefixed tokens and ids are longer than the old random strings
+	`ALTER TABLE users ALTER COLUMN verification_token TYPE TEXT`,
+	`ALTER TABLE orders ALTER COLUMN numero TYPE TEXT`,
+	`CREATE TABLE IF NOT EXISTS user_mfa (
+		user_id        INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+		secret         TEXT NOT NULL,
+		enabled        BOOLEAN NOT NULL DEFAULT false,
+		last_used_step BIGINT NOT NULL DEFAULT 0,
+		created_at     TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+		id        SERIAL PRIMARY KEY,
+		user_id   INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		code_hash TEXT NOT NULL,
+		used_at   TIMESTAMPTZ
+	)`,
+	// AES-GCM encrypted address, to mail users whose email is only stored hashed
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_encrypted TEXT`,
+	`CREATE TABLE IF NOT EXISTS roles (
+		id          SERIAL PRIMARY KEY,
+		name        TEXT NOT NULL UNIQUE,
+		description TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS permissions (
+		name        TEXT PRIMARY KEY,
+		description TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS role_permissions (
+		role_id    INTEGER NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+		permission TEXT NOT NULL REFERENCES permissions (name) ON DELETE CASCADE,
+		PRIMARY KEY (role_id, permission)
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_roles (
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		role_id INTEGER NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+		PRIMARY KEY (user_id, role_id)
+	)`,
+	`INSERT INTO permissions (name, description) VALUES
+		('product:write', 'Create, edit and delete products'),
+		('faq:write', 'Create, edit and delete FAQ entries'),
+		('user:read', 'List users'),
+		('user:write', 'Delete users and end their sessions'),
+		('order:read', 'Read every order'),
+		('log:read', 'Read the request logs'),
+		('log:write', 'Delete request logs'),
+		('role:write', 'Assign roles to users')
+	ON CONFLICT (name) DO NOTHING`,
+	`INSERT INTO roles (name, description) VALUES
+		('super-admin', 'Every permission'),
+		('catalog-editor', 'Product and FAQ management'),
+		('support', 'Read access to users and orders'),
+		('auditor', 'Read access to the logs')
+	ON CONFLICT (name) DO NOTHING`,
+	`INSERT INTO role_permissions (role_id, permission)
+		SELECT r.id, p.name FROM roles r CROSS JOIN permissions p WHERE r.name = 'super-admin'
+	ON CONFLICT DO NOTHING`,
+	`INSERT INTO role_permissions (role_id, permission)
+		SELECT r.id, p.permission FROM roles r JOIN (VALUES
+			('catalog-editor', 'product:write'),
+			('catalog-editor', 'faq:write'),
+			('support', 'user:read'),
+			('support', 'order:read'),
+			('auditor', 'log:read')
+		) AS p (role, permission) ON p.role = r.name
+	ON CONFLICT DO NOTHING`,
+	// admins from before roles existed become super-admins, only while no
+	// role has been handed out yet so that later demotions stick
+	`INSERT INTO user_roles (user_id, role_id)
+		SELECT u.id, r.id FROM users u CROSS JOIN roles r
+		WHERE u.is_admin AND r.name = 'super-admin'
+		AND NOT EXISTS (SELECT 1 FROM user_roles)`,
+	// the cart used to get a new row every time a product was added: merge
+	// the duplicates into the first line before making the pair unique
+	`WITH duplicates AS (
+		DELETE FROM cart a USING cart b
+		WHERE a.user_id = b.user_id AND a.product_id = b.product_id AND a.ctid > b.ctid
+		RETURNING a.user_id, a.product_id, a.quantity
+	)
+	UPDATE cart c SET quantity = c.quantity + d.quantity
+	FROM (SELECT user_id, product_id, SUM(quantity) AS quantity FROM duplicates GROUP BY user_id, product_id) d
+	WHERE c.user_id = d.user_id AND c.product_id = d.product_id
+		AND NOT EXISTS (
+			SELECT 1 FROM cart e
+			WHERE e.user_id = c.user_id AND e.product_id = c.product_id AND e.ctid < c.ctid
+		)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS cart_user_product_key ON cart (user_id, product_id)`,
+	`ALTER TABLE contains_product ADD COLUMN IF NOT EXISTS unit_price NUMERIC(15, 3)`,
+	`CREATE TABLE IF NOT EXISTS order_status_history (
+		id          SERIAL PRIMARY KEY,
+		order_id    INTEGER NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+		from_status TEXT,
+		to_status   TEXT NOT NULL,
+		changed_by  INTEGER REFERENCES users (id) ON DELETE SET NULL,
+		note        TEXT NOT NULL DEFAULT '',
+		changed_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS order_status_history_order_idx ON order_status_history (order_id, changed_at)`,
+	`INSERT INTO permissions (name, description) VALUES
+		('order:write', 'Change the status of orders')
+	ON CONFLICT (name) DO NOTHING`,
+	`INSERT INTO role_permissions (role_id, permission)
+		SELECT id, 'order:write' FROM roles WHERE name = 'super-admin'
+	ON CONFLICT DO NOTHING`,
+	`ALTER TABLE contains_product ADD COLUMN IF NOT EXISTS product_name TEXT`,
+	`CREATE TABLE IF NOT EXISTS user_addresses (
+		id          SERIAL PRIMARY KEY,
+		user_id     INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		label       TEXT NOT NULL DEFAULT '',
+		full_name   TEXT NOT NULL,
+		line1       TEXT NOT NULL,
+		line2       TEXT NOT NULL DEFAULT '',
+		postal_code TEXT NOT NULL,
+		city        TEXT NOT NULL,
+		country     CHAR(2) NOT NULL,
+		phone       TEXT NOT NULL DEFAULT '',
+		is_default  BOOLEAN NOT NULL DEFAULT false,
+		created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS user_addresses_user_idx ON user_addresses (user_id)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS user_addresses_default_key ON user_addresses (user_id) WHERE is_default`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_address JSONB`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_provider TEXT`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_intent_id TEXT`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_refund_id TEXT`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_refund_claimed_at TIMESTAMPTZ`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS orders_payment_intent_key ON orders (payment_provider, payment_intent_id)`,
+	`CREATE TABLE IF NOT EXISTS payment_events (
+		provider    TEXT NOT NULL,
+		event_id    TEXT NOT NULL,
+		order_id    INTEGER NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+		status      TEXT NOT NULL,
+		received_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (provider, event_id)
+	)`,
+	// every intent started for an order, the ones replaced by a retry still
+	// leading to it. paid marks the one the order was paid with, refund_due
+	// one that was captured when the order could no longer take it.
+	`CREATE TABLE IF NOT EXISTS payment_intents (
+		provider   TEXT NOT NULL,
+		intent_id  TEXT NOT NULL,
+		order_id   INTEGER NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+		amount     NUMERIC(15, 3) NOT NULL,
+		currency   TEXT NOT NULL,
+		paid       BOOLEAN NOT NULL DEFAULT false,
+		refund_due BOOLEAN NOT NULL DEFAULT false,
+		refund_id  TEXT,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (provider, intent_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS payment_intents_order_idx ON payment_intents (order_id)`,
+	`CREATE TABLE IF NOT EXISTS promo_codes (
+		id                SERIAL PRIMARY KEY,
+		code              TEXT NOT NULL UNIQUE,
+		kind              TEXT NOT NULL CHECK (kind IN ('percent', 'fixed')),
+		percent_bp        INTEGER,
+		amount            NUMERIC(15, 3) NOT NULL DEFAULT 0,
+		min_basket        NUMERIC(15, 3) NOT NULL DEFAULT 0,
+		currency          TEXT NOT NULL,
+		product_ids       INTEGER[] NOT NULL DEFAULT '{}',
+		category_ids      INTEGER[] NOT NULL DEFAULT '{}',
+		max_uses          INTEGER,
+		max_uses_per_user INTEGER,
+		starts_at         TIMESTAMPTZ,
+		ends_at           TIMESTAMPTZ,
+		active            BOOLEAN NOT NULL DEFAULT true,
+		created_at        TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS cart_promo (
+		user_id       INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+		promo_code_id INTEGER NOT NULL REFERENCES promo_codes (id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS promo_redemptions (
+		id            SERIAL PRIMARY KEY,
+		promo_code_id INTEGER NOT NULL REFERENCES promo_codes (id) ON DELETE CASCADE,
+		user_id       INTEGER REFERENCES users (id) ON DELETE SET NULL,
+		order_id      INTEGER NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+		redeemed_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS promo_redemptions_code_user_idx ON promo_redemptions (promo_code_id, user_id)`,
+	`CREATE TABLE IF NOT EXISTS order_discounts (
+		id            SERIAL PRIMARY KEY,
+		order_id      INTEGER NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+		promo_code_id INTEGER REFERENCES promo_codes (id) ON DELETE SET NULL,
+		code          TEXT NOT NULL,
+		amount        NUMERIC(15, 3) NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS order_discounts_order_idx ON order_discounts (order_id)`,
+	`INSERT INTO permissions (name, description) VALUES
+		('promo:write', 'Create, edit and delete promo codes')
+	ON CONFLICT (name) DO NOTHING`,
+	`INSERT INTO role_permissions (role_id, permission)
+		SELECT id, 'promo:write' FROM roles WHERE name IN ('super-admin', 'catalog-editor')
+	ON CONFLICT DO NOTHING`,
+	// full-text search: search_document holds the stemmed words of the product
+	// and of its taxonomy terms, search_text the raw words for the typo
+	// tolerant trigram match, see enableTrigrams. Triggers keep both up to date.
+	`ALTER TABLE product ADD COLUMN IF NOT EXISTS search_document TSVECTOR`,
+	`ALTER TABLE product ADD COLUMN IF NOT EXISTS search_text TEXT NOT NULL DEFAULT ''`,
+	`CREATE OR REPLACE FUNCTION refresh_product_search(pid INTEGER) RETURNS void AS $$
+		UPDATE product p SET
+			search_text = concat_ws(' ', p.name, p.genetics, t.names),
+			search_document =
+				setweight(to_tsvector('french', coalesce(p.name, '')), 'A') ||
+				setweight(to_tsvector('english', coalesce(p.name, '')), 'A') ||
+				setweight(to_tsvector('french', concat_ws(' ', p.genetics, t.names)), 'B') ||
+				setweight(to_tsvector('english', concat_ws(' ', p.genetics, t.names)), 'B') ||
+				setweight(to_tsvector('french', coalesce(p.description, '')), 'C') ||
+				setweight(to_tsvector('english', coalesce(p.description, '')), 'C')
+		FROM (
+			SELECT string_agg(name, ' ') AS names FROM (
+				SELECT t.name FROM has_flavor h JOIN flavor t ON t.id = h.flavor_id WHERE h.product_id = pid
+				UNION ALL SELECT t.name FROM has_aspect h JOIN aspect t ON t.id = h.aspect_id WHERE h.product_id = pid
+				UNION ALL SELECT t.name FROM has_effect h JOIN effet t ON t.id = h.effect_id WHERE h.product_id = pid
+				UNION ALL SELECT t.name FROM is_ideal_for h JOIN ideal_for t ON t.id = h.ideal_for_id WHERE h.product_id = pid
+				UNION ALL SELECT t.name FROM belongs_to h JOIN category t ON t.id = h.category_id WHERE h.product_id = pid
+			) terms
+		) t
+		WHERE p.id = pid
+	$$ LANGUAGE sql`,
+	`CREATE OR REPLACE FUNCTION product_search_product_changed() RETURNS trigger AS $$
+	BEGIN
+		PERFORM refresh_product_search(NEW.id);
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`CREATE OR REPLACE FUNCTION product_search_link_changed() RETURNS trigger AS $$
+	BEGIN
+		IF TG_OP IN ('UPDATE', 'DELETE') THEN
+			PERFORM refresh_product_search(OLD.product_id);
+		END IF;
+		IF TG_OP IN ('INSERT', 'UPDATE') THEN
+			PERFORM refresh_product_search(NEW.product_id);
+		END IF;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	// the arguments are the link table and its term column
+	`CREATE OR REPLACE FUNCTION product_search_term_renamed() RETURNS trigger AS $$
+	DECLARE
+		pid INTEGER;
+	BEGIN
+		FOR pid IN EXECUTE format('SELECT product_id FROM %I WHERE %I = $1', TG_ARGV[0], TG_ARGV[1]) USING NEW.id LOOP
+			PERFORM refresh_product_search(pid);
+		END LOOP;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS product_search ON product`,
+	`CREATE TRIGGER product_search AFTER INSERT OR UPDATE OF name, genetics, description ON product
+		FOR EACH ROW EXECUTE FUNCTION product_search_product_changed()`,
+	`DROP TRIGGER IF EXISTS product_search ON has_flavor`,
+	`CREATE TRIGGER product_search AFTER INSERT OR UPDATE OR DELETE ON has_flavor
+		FOR EACH ROW EXECUTE FUNCTION product_search_link_changed()`,
+	`DROP TRIGGER IF EXISTS product_search ON flavor`,
+	`CREATE TRIGGER product_search AFTER UPDATE OF name ON flavor
+		FOR EACH ROW EXECUTE FUNCTION product_search_term_renamed('has_flavor', 'flavor_id')`,
+	`DROP TRIGGER IF EXISTS product_search ON has_aspect`,
+	`CREATE TRIGGER product_search AFTER INSERT OR UPDATE OR DELETE ON has_aspect
+		FOR EACH ROW EXECUTE FUNCTION product_search_link_changed()`,
+	`DROP TRIGGER IF EXISTS product_search ON aspect`,
+	`CREATE TRIGGER product_search AFTER UPDATE OF name ON aspect
+		FOR EACH ROW EXECUTE FUNCTION product_search_term_renamed('has_aspect', 'aspect_id')`,
+	`DROP TRIGGER IF EXISTS product_search ON has_effect`,
+	`CREATE TRIGGER product_search AFTER INSERT OR UPDATE OR DELETE ON has_effect
+		FOR EACH ROW EXECUTE FUNCTION product_search_link_changed()`,
+	`DROP TRIGGER IF EXISTS product_search ON effet`,
+	`CREATE TRIGGER product_search AFTER UPDATE OF name ON effet
+		FOR EACH ROW EXECUTE FUNCTION product_search_term_renamed('has_effect', 'effect_id')`,
+	`DROP TRIGGER IF EXISTS product_search ON is_ideal_for`,
+	`CREATE TRIGGER product_search AFTER INSERT OR UPDATE OR DELETE ON is_ideal_for
+		FOR EACH ROW EXECUTE FUNCTION product_search_link_changed()`,
+	`DROP TRIGGER IF EXISTS product_search ON ideal_for`,
+	`CREATE TRIGGER product_search AFTER UPDATE OF name ON ideal_for
+		FOR EACH ROW EXECUTE FUNCTION product_search_term_renamed('is_ideal_for', 'ideal_for_id')`,
+	`DROP TRIGGER IF EXISTS product_search ON belongs_to`,
+	`CREATE TRIGGER product_search AFTER INSERT OR UPDATE OR DELETE ON belongs_to
+		FOR EACH ROW EXECUTE FUNCTION product_search_link_changed()`,
+	`DROP TRIGGER IF EXISTS product_search ON category`,
+	`CREATE TRIGGER product_search AFTER UPDATE OF name ON category
+		FOR EACH ROW EXECUTE FUNCTION product_search_term_renamed('belongs_to', 'category_id')`,
+	`SELECT refresh_product_search(id) FROM product WHERE search_document IS NULL`,
+	`CREATE INDEX IF NOT EXISTS product_search_document_idx ON product USING GIN (search_document)`,
+	// taxonomy names are unique whatever their case: terms created twice by
+	// AddProduct are merged into the oldest one first
+	`DO $$
+	DECLARE
+		t RECORD;
+	BEGIN
+		FOR t IN SELECT * FROM (VALUES
+			('flavor', 'has_flavor', 'flavor_id'),
+			('aspect', 'has_aspect', 'aspect_id'),
+			('effet', 'has_effect', 'effect_id'),
+			('ideal_for', 'is_ideal_for', 'ideal_for_id'),
+			('category', 'belongs_to', 'category_id')
+		) AS v (term, link, col) LOOP
+			EXECUTE format('
+				CREATE TEMP TABLE duplicate_terms AS
+				SELECT id, keep FROM (SELECT id, min(id) OVER (PARTITION BY lower(name)) AS keep FROM %I) d
+				WHERE id <> keep', t.term);
+			EXECUTE format('
+				INSERT INTO %1$I (product_id, %2$I)
+				SELECT DISTINCT h.product_id, d.keep FROM %1$I h JOIN duplicate_terms d ON d.id = h.%2$I
+				WHERE NOT EXISTS (SELECT 1 FROM %1$I e WHERE e.product_id = h.product_id AND e.%2$I = d.keep)', t.link, t.col);
+			EXECUTE format('DELETE FROM %I WHERE %I IN (SELECT id FROM duplicate_terms)', t.link, t.col);
+			EXECUTE format('DELETE FROM %I WHERE id IN (SELECT id FROM duplicate_terms)', t.term);
+			DROP TABLE duplicate_terms;
+			EXECUTE format('CREATE UNIQUE INDEX IF NOT EXISTS %I ON %I (lower(name))', t.term || '_name_key', t.term);
+		END LOOP;
+	END
+	$$`,
+	`CREATE TABLE IF NOT EXISTS login_attempts (
+		key          TEXT PRIMARY KEY,
+		failures     INTEGER NOT NULL,
+		last_failure TIMESTAMPTZ NOT NULL,
+		locked_until TIMESTAMPTZ NOT NULL
+	)`,
+	// amounts are exact decimals in major units, with the 3 decimals of the
+	// currencies that have the most, whatever type the base tables used
+	`DO $$
+	DECLARE
+		c RECORD;
+	BEGIN
+		FOR c IN SELECT table_name, column_name FROM information_schema.columns
+			WHERE table_schema = current_schema()
+				AND (table_name::TEXT, column_name::TEXT) IN (('product', 'price'), ('orders', 'price'))
+				AND (data_type <> 'numeric' OR numeric_precision IS DISTINCT FROM 15 OR numeric_scale IS DISTINCT FROM 3)
+		LOOP
+			EXECUTE format('ALTER TABLE %I ALTER COLUMN %I TYPE NUMERIC(15, 3) USING round(%I::NUMERIC, 3)', c.table_name, c.column_name, c.column_name);
+		END LOOP;
+	END $$`,
+	// filled by Migrate for the orders from before it was kept
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS currency TEXT`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_currency TEXT`,
+}
+
+// Trigrams tells whether the pg_trgm extension is installed, the catalog
+// search only tolerating typos with it
+var Trigrams bool
+
+// trigramSchema is applied once pg_trgm is installed
+var trigramSchema = []string{
+	`CREATE INDEX IF NOT EXISTS product_search_text_idx ON product USING GIN (search_text gin_trgm_ops)`,
+}
+
+// Migrate applies the schema statements to the connected database. The
+// orders from before their currency was kept are taken to be in currency,
+// the one the shop is configured with.
+func Migrate(currency string) error {
+	for _, stmt := range schema {
+		if _, err := DB.Exec(stmt); err != nil {
+			return fmt.Errorf("migration failed: %w", err)
+		}
+	}
+
+	if _, err := DB.Exec("UPDATE orders SET currency = $1 WHERE currency IS NULL", currency); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	if _, err := DB.Exec("ALTER TABLE orders ALTER COLUMN currency SET NOT NULL"); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	return enableTrigrams()
+}
+
+// enableTrigrams installs pg_trgm, which takes a superuser or the owner of
+// the database. Without these rights the server still starts, as long as an
+// administrator has not installed the extension the search matches whole
+// words only.
+func enableTrigrams() error {
+	if _, err := DB.Exec(`CREATE EXTENSION IF NOT EXISTS pg_trgm`); err != nil {
+		fmt.Println("Error installing pg_trgm, the search will not tolerate typos:", err)
+	}
+
+	err := DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_trgm')`).Scan(&Trigrams)
+	if err != nil || !Trigrams {
+		return err
+	}
+	for _, stmt := range trigramSchema {
+		if _, err := DB.Exec(stmt); err != nil {
+			return fmt.Errorf("migration failed: %w", err)
+		}
+	}
+	return nil
+}
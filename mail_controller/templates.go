@@ -0,0 +1,21 @@
+package mailcontroller
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+)
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+var templates = template.Must(template.ParseFS(templateFiles, "templates/*.html"))
+
+// SendTemplate renders templates/<name> with data and mails the result
+func SendTemplate(to, subject, name string, data any) error {
+	var body bytes.Buffer
+	if err := templates.ExecuteTemplate(&body, name, data); err != nil {
+		return err
+	}
+	return SendMail(to, subject, body.String())
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -16,6 +21,8 @@ import (
 	mailcontroller "sec-app-server/mail_controller"
 	m "sec-app-server/middlewares"
 	mod "sec-app-server/model"
+	"sec-app-server/money"
+	"sec-app-server/payments"
 	"sec-app-server/utils"
 )
 
@@ -23,18 +30,37 @@ func main() {
 	r := gin.Default()
 
 	r.Use(m.LogRequest())
+	r.Use(m.Authenticate())
 
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatal("Error loading .env file")
 	}
 
-	controller.LoadJWTSecret()
+	if err := controller.LoadJWTKeys(); err != nil {
+		log.Fatal("Failed to load the JWT keys:", err)
+	}
+	controller.LoadMFAConfig()
 
 	mailcontroller.InitMailSystem()
 
 	utils.ClientUrl = os.Getenv("CLIENT_URL")
 
+	if currency := os.Getenv("CURRENCY"); currency != "" {
+		if err := money.SetDefaultCurrency(currency); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := payments.Load(); err != nil {
+		log.Fatal("Failed to set up payments:", err)
+	}
+
+	// contact emails and two-factor secrets cannot be stored without it
+	if err := utils.LoadEncryptionKey(os.Getenv("DATA_ENCRYPTION_KEY")); err != nil {
+		log.Fatal("Failed to load the data encryption key:", err)
+	}
+
 	origins := utils.ClientUrl
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{origins},
@@ -45,24 +71,36 @@ func main() {
 
 	initUserRoutes(r)
 	initProductRoutes(r)
+	initTaxonomyRoutes(r)
 	initFAQRoutes(r)
 	initLogsRoutes(r)
 
 	r.Static("/uploads", "./uploads")
 
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, gin.H{"keys": controller.JWKS()})
+	})
+
 	err = db.InitDB()
 
 	if err != nil {
 		log.Fatal("Failed to connect to the database:", err)
 	}
 
+	if err = db.Migrate(money.DefaultCurrency); err != nil {
+		log.Fatal(err)
+	}
+
 	db.Test()
 
 	r.Run(":8080")
 }
 
 func initUserRoutes(r *gin.Engine) {
-	r.POST("/register", func(c *gin.Context) {
+	authLimit := m.RateLimit("auth", "10/1m")
+
+	r.POST("/register", m.RateLimit("register", "5/1h"), func(c *gin.Context) {
 		var creds struct {
 			Username string `json:"username" binding:"required"`
 			Mail     string `json:"email" binding:"required"`
@@ -108,7 +146,7 @@ func initUserRoutes(r *gin.Engine) {
 		c.JSON(http.StatusOK, gin.H{"message": "User registered"})
 	})
 
-	r.POST("/login", func(c *gin.Context) {
+	r.POST("/login", authLimit, func(c *gin.Context) {
 		var creds controller.Credentials
 		if err := c.ShouldBindJSON(&creds); err != nil {
 			fmt.Println(creds)
@@ -119,6 +157,18 @@ func initUserRoutes(r *gin.Engine) {
 		userInfo, err := mod.GetUserByEmailOrUsername(creds.MailOrUsername, false)
 
 		fmt.Println(err)
+		throttleKey := controller.AccountThrottleKey(userInfo, creds.MailOrUsername)
+		if wait, err := controller.LoginRetryAfter(throttleKey, c.ClientIP()); err != nil || wait > 0 {
+			respondTooManyAttempts(c, wait)
+			return
+		}
+
+		if err != nil {
+			utils.BurnPasswordCheck(creds.Password)
+			controller.RecordLoginFailure(throttleKey, c.ClientIP())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			return
+		}
 
 		isUserVerified := mod.IsUserVerified(userInfo.Email)
 		if !isUserVerified {
@@ -129,44 +179,181 @@ func initUserRoutes(r *gin.Engine) {
 		user, err := mod.AuthenticateUser(creds.MailOrUsername, creds.Password)
 
 		if err != nil {
+			locked, _ := controller.RecordLoginFailure(throttleKey, c.ClientIP())
+			if locked {
+				controller.WarnAccountLocked(userInfo, creds.MailOrUsername)
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 			return
 		}
 
 		fmt.Println("User authenticated:", user)
 
+		if strings.Contains(creds.MailOrUsername, "@") {
+			mod.RememberContactEmail(user.ID, creds.MailOrUsername)
+		}
+
+		fmt.Println(userInfo)
+		mfaEnabled, err := mod.IsMFAEnabled(user.ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting the user"})
 			return
 		}
 
-		fmt.Println(userInfo)
-		tokenString, err := controller.EncodeJWT(userInfo.Email)
+		if mfaEnabled {
+			mfaToken, err := controller.EncodeMFAPendingJWT(user)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+				return
+			}
+			// the counter is only cleared once the second factor is checked,
+			// the password alone must not reset the MFA guesses
+			c.JSON(http.StatusOK, gin.H{
+				"mfa_required": true,
+				"mfa_token":    mfaToken,
+			})
+			return
+		}
+
+		controller.RecordLoginSuccess(throttleKey)
+		respondWithSession(c, user)
+	})
+
+	r.POST("/login/mfa", authLimit, func(c *gin.Context) {
+		var body struct {
+			MFAToken string `json:"mfa_token" binding:"required"`
+			Code     string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		pending, err := controller.DecodeMFAPendingJWT(body.MFAToken)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "mfa-token:invalid"})
 			return
+		}
 
+		user, err := mod.GetUserByID(pending.UserID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "mfa-token:invalid"})
+			return
 		}
-		c.JSON(http.StatusOK, gin.H{
-			"token":    tokenString,
-			"username": user.Username,
-			"is_admin": user.IsAdmin,
-		})
+
+		// the same key as /login, so that failed codes lock the account too
+		throttleKey := controller.AccountThrottleKey(user, "")
+		if wait, err := controller.LoginRetryAfter(throttleKey, c.ClientIP()); err != nil || wait > 0 {
+			respondTooManyAttempts(c, wait)
+			return
+		}
+
+		err = mod.VerifyMFACode(user.ID, body.Code)
+		if errors.Is(err, mod.ErrMFAInvalidCode) {
+			locked, _ := controller.RecordLoginFailure(throttleKey, c.ClientIP())
+			if locked {
+				controller.WarnAccountLocked(user, "")
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error verifying code"})
+			return
+		}
+
+		if err := controller.RevokeJWT(pending.TokenID, pending.ExpiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error verifying code"})
+			return
+		}
+		controller.RecordLoginSuccess(throttleKey)
+
+		respondWithSession(c, user)
 	})
 
-	r.POST("/disconnect", func(c *gin.Context) {
-		// Invalidate JWT
-		c.JSON(http.StatusOK, gin.H{"message": "Disconnected"})
+	r.POST("/token/refresh", m.RateLimit("refresh", "30/1m"), func(c *gin.Context) {
+		var body struct {
+			RefreshToken string `json:"refresh_token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		tokens, err := controller.RefreshJWT(body.RefreshToken)
+		switch {
+		case errors.Is(err, mod.ErrRefreshTokenReused):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh-token:reused"})
+			return
+		case errors.Is(err, mod.ErrRefreshTokenExpired):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh-token:expired"})
+			return
+		case errors.Is(err, mod.ErrRefreshTokenInvalid):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh-token:invalid"})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not refresh token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, tokens)
 	})
 
-	r.DELETE("/admin/user/:id", m.AdminAuthenticated(func(c *gin.Context) {
+	r.POST("/disconnect", m.Authenticated(func(c *gin.Context) {
+		principal := controller.MustGetPrincipal(c)
+
+		if err := controller.RevokeJWT(principal.TokenID, principal.ExpiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disconnect"})
+			return
+		}
+
+		var body struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if c.ShouldBindJSON(&body) == nil && body.RefreshToken != "" {
+			if err := mod.RevokeRefreshTokenFamily(principal.Email, body.RefreshToken); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disconnect"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Disconnected"})
+	}))
+
+	r.POST("/disconnect/all", m.Authenticated(func(c *gin.Context) {
+		principal := controller.MustGetPrincipal(c)
+
+		if err := controller.RevokeAllUserTokens(principal.Email); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disconnect"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Disconnected from all devices"})
+	}))
+
+	r.POST("/admin/user/:id/disconnect", m.RequirePermission(mod.PermUserWrite)(func(c *gin.Context) {
+		user, err := mod.GetUserByID(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		if err := controller.RevokeAllUserTokens(user.Email); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disconnect user"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "User disconnected from all devices"})
+	}))
+
+	r.DELETE("/admin/user/:id", m.RequirePermission(mod.PermUserWrite)(func(c *gin.Context) {
 		id := c.Param("id")
 		if id == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "ID is required"})
 			return
 		}
 
-		err := mod.RemoveUser(id)
+		err := controller.DeleteUser(id)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove user"})
 			return
@@ -176,13 +363,9 @@ func initUserRoutes(r *gin.Engine) {
 	}))
 
 	r.DELETE("/user", m.Authenticated(func(c *gin.Context) {
-		email, err := controller.GetUserEmailFromGinContext(c)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove user"})
-			return
-		}
+		principal := controller.MustGetPrincipal(c)
 
-		err = mod.RemoveUser(email)
+		err := controller.DeleteUser(principal.UserID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove user"})
 			return
@@ -191,7 +374,7 @@ func initUserRoutes(r *gin.Engine) {
 		c.JSON(http.StatusOK, gin.H{"message": "User removed successfully"})
 	}))
 
-	r.GET("/user", m.AdminAuthenticated(func(c *gin.Context) {
+	r.GET("/user", m.RequirePermission(mod.PermUserRead)(func(c *gin.Context) {
 		users, err := mod.GetAllUser()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
@@ -200,13 +383,23 @@ func initUserRoutes(r *gin.Engine) {
 		c.JSON(http.StatusOK, users)
 	}))
 
-	r.POST("/user/verify/:token", func(c *gin.Context) {
+	verifyLimit := m.RateLimit("verify", "10/1m")
+
+	r.POST("/user/verify/:token", verifyLimit, func(c *gin.Context) {
 		token := c.Param("token")
 		if token == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Token is required"})
 			return
 		}
 		err := mod.VerifyUser(token)
+		if errors.Is(err, mod.ErrVerificationTokenExpired) {
+			c.JSON(http.StatusGone, gin.H{"error": "expired:verification-token"})
+			return
+		}
+		if errors.Is(err, mod.ErrVerificationTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:verification-token"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify user"})
 			return
@@ -214,27 +407,35 @@ func initUserRoutes(r *gin.Engine) {
 		c.JSON(http.StatusOK, gin.H{"message": "User verified successfully"})
 	})
 
-	r.GET("/user/me", m.Authenticated(func(c *gin.Context) {
-		userMail, err := controller.GetUserEmailFromGinContext(c)
-		fmt.Println("User email from context:", userMail)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting the user"})
+	r.POST("/user/verify/resend", verifyLimit, func(c *gin.Context) {
+		var body struct {
+			Email string `json:"email" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 			return
 		}
 
-		user, err := mod.GetUserByEmailOrUsername(userMail, true)
+		mod.ResendVerification(body.Email)
+
+		c.JSON(http.StatusOK, gin.H{"message": "If an unverified account exists for this email, a new verification link has been sent"})
+	})
+
+	r.GET("/user/me", m.Authenticated(func(c *gin.Context) {
+		principal := controller.MustGetPrincipal(c)
+
+		user, err := mod.GetUserByID(principal.UserID)
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"username": user.Username, "is_admin": user.IsAdmin})
+		c.JSON(http.StatusOK, gin.H{"username": user.Username, "is_admin": user.IsAdmin, "roles": principal.Roles})
 	}))
 
 	r.PUT("/user/change-password", m.Authenticated(func(c *gin.Context) {
-		userMail, _ := controller.GetUserEmailFromGinContext(c)
-		user, _ := mod.GetUserByEmailOrUsername(userMail, true)
+		principal := controller.MustGetPrincipal(c)
 		var json struct {
 			OldPassword string `json:"oldPassword"`
 			NewPassword string `json:"newPassword"`
@@ -245,7 +446,7 @@ func initUserRoutes(r *gin.Engine) {
 			return
 		}
 
-		if !mod.IsPasswordCorrect(userMail, utils.HashString(json.OldPassword)) {
+		if !mod.IsPasswordCorrect(principal.Email, json.OldPassword) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "old password is not correct"})
 			return
 		}
@@ -257,7 +458,7 @@ func initUserRoutes(r *gin.Engine) {
 			return
 		}
 
-		err := mod.ChangeUserPassword(user.ID, json.NewPassword)
+		err := mod.ChangeUserPassword(principal.UserID, json.NewPassword)
 
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Error updating password"})
@@ -267,207 +468,1248 @@ func initUserRoutes(r *gin.Engine) {
 		c.JSON(http.StatusOK, gin.H{"message": "Password changged successfully"})
 	}))
 
-	r.POST("/user/make-admin/:id", m.AdminAuthenticated(func(c *gin.Context) {
-		id := c.Param("id")
+	passwordResetLimit := m.RateLimit("password_reset", "5/15m")
 
-		err := mod.MakeUserAdmin(id)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error whihle making the user admin"})
+	r.POST("/user/password-reset/request", passwordResetLimit, func(c *gin.Context) {
+		var body struct {
+			Email string `json:"email" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "the user is now admin !"})
-	}))
+		// the answer is the same whether the account exists or not, and the
+		// mail goes out in the background so the timing does not tell either
+		go func(email string) {
+			user, err := mod.GetUserByEmail(email)
+			if err != nil {
+				return
+			}
 
-	r.GET("/user/orders", m.Authenticated(func(c *gin.Context) {
-		email, err := controller.GetUserEmailFromGinContext(c)
+			token, err := mod.CreatePasswordResetToken(user.ID)
+			if err != nil {
+				return
+			}
+
+			err = mailcontroller.SendMail(email, "Password reset", fmt.Sprintf("You can choose a new password by clicking the following link, it is valid for one hour: %s/reset-password/%s", utils.ClientUrl, token))
+			if err != nil {
+				fmt.Println("Error sending password reset email:", err)
+			}
+		}(body.Email)
+
+		c.JSON(http.StatusOK, gin.H{"message": "If an account exists for this email, a reset link has been sent"})
+	})
+
+	r.POST("/user/password-reset/confirm", passwordResetLimit, func(c *gin.Context) {
+		var body struct {
+			Token       string `json:"token" binding:"required"`
+			NewPassword string `json:"newPassword" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if !utils.PasswordValidator(body.NewPassword) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Password is not conform"})
+			return
+		}
+
+		user, err := mod.ResetPassword(body.Token, body.NewPassword)
+		if errors.Is(err, mod.ErrPasswordResetTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:reset-token"})
+			return
+		}
 		if err != nil {
-			c.JSON(401, gin.H{"error": "unauthorized"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating password"})
 			return
 		}
 
-		user, err := mod.GetUserByEmailOrUsername(email, true)
+		// whoever knew the old password must not stay logged in
+		if err := controller.RevokeAllUserTokens(user.Email); err != nil {
+			fmt.Println("Error revoking sessions after password reset:", err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+	})
+
+	r.POST("/user/mfa/enroll", m.Authenticated(func(c *gin.Context) {
+		user, err := controller.GetCurrentUser(c)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "failed to get user"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting the user"})
 			return
 		}
 
-		orders, err := mod.GetAllOrdersFromUser(user.ID)
+		secret, err := mod.StartMFAEnrollment(user.ID)
+		if errors.Is(err, mod.ErrMFAAlreadyEnabled) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+			return
+		}
 		if err != nil {
-			c.JSON(500, gin.H{"error": "failed to get orders"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
 			return
 		}
 
-		c.JSON(200, gin.H{"orders": orders})
+		c.JSON(http.StatusOK, gin.H{
+			"secret":      secret,
+			"otpauth_uri": utils.TOTPURI(controller.MFAConfig.Issuer, user.Username, secret),
+		})
 	}))
 
-	r.POST("/cart/add/", m.Authenticated(func(c *gin.Context) {
-		var prodQuant struct {
-			ProductID string `json:"product_id" binding:"required"`
-			Quantity  int    `json:"quantity" binding:"required,min=1"`
+	r.POST("/user/mfa/confirm", m.Authenticated(func(c *gin.Context) {
+		var body struct {
+			Code string `json:"code" binding:"required"`
 		}
-
-		if err := c.ShouldBindJSON(&prodQuant); err != nil {
+		if err := c.ShouldBindJSON(&body); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 			return
 		}
 
-		if prodQuant.ProductID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Product ID is required"})
-			return
-		}
-		if prodQuant.Quantity < 1 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be at least 1"})
+		user, err := controller.GetCurrentUser(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting the user"})
 			return
 		}
 
-		userID, err := controller.GetUserEmailFromGinContext(c)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		throttleKey := controller.MFAThrottleKey(user.ID)
+		if wait, err := controller.LoginRetryAfter(throttleKey, c.ClientIP()); err != nil || wait > 0 {
+			respondTooManyAttempts(c, wait)
 			return
 		}
 
-		err = mod.AddProductToCart(userID, prodQuant.ProductID, prodQuant.Quantity)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add product to cart"})
+		recoveryCodes, err := mod.ConfirmMFAEnrollment(user.ID, body.Code)
+		switch {
+		case errors.Is(err, mod.ErrMFAInvalidCode):
+			controller.RecordLoginFailure(throttleKey, c.ClientIP())
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+			return
+		case errors.Is(err, mod.ErrMFANotPending):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No enrollment in progress"})
+			return
+		case errors.Is(err, mod.ErrMFAAlreadyEnabled):
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm enrollment"})
 			return
 		}
+		controller.RecordLoginSuccess(throttleKey)
 
-		c.JSON(http.StatusOK, gin.H{"message": "Product added to cart successfully"})
+		c.JSON(http.StatusOK, gin.H{
+			"message":        "Two-factor authentication enabled",
+			"recovery_codes": recoveryCodes,
+		})
 	}))
 
-	r.POST("/order", m.Authenticated(func(c *gin.Context) {
-		userID, err := controller.GetUserEmailFromGinContext(c)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	r.DELETE("/user/mfa", m.Authenticated(func(c *gin.Context) {
+		var body struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 			return
 		}
 
-		err = mod.OrderCart(userID)
+		user, err := controller.GetCurrentUser(c)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting the user"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Order created successfully"})
-	}))
-}
+		throttleKey := controller.MFAThrottleKey(user.ID)
+		if wait, err := controller.LoginRetryAfter(throttleKey, c.ClientIP()); err != nil || wait > 0 {
+			respondTooManyAttempts(c, wait)
+			return
+		}
 
-func initProductRoutes(r *gin.Engine) {
-	r.GET("/product", m.AdminAuthenticated(func(c *gin.Context) {
-		products, err := mod.GetProducts()
+		err = mod.VerifyMFACode(user.ID, body.Code)
+		if errors.Is(err, mod.ErrMFAInvalidCode) {
+			controller.RecordLoginFailure(throttleKey, c.ClientIP())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error verifying code"})
 			return
 		}
-		c.JSON(http.StatusOK, products)
-	}))
+		controller.RecordLoginSuccess(throttleKey)
 
-	r.GET("/product/:id", m.Authenticated(func(c *gin.Context) {
-		id := c.Param("id")
-		if id == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Product ID is required"})
+		if err := mod.DisableMFA(user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
 			return
 		}
 
-		fmt.Println()
-		product, err := mod.GetProductByID(id)
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+	}))
 
+	r.GET("/admin/roles", m.RequirePermission(mod.PermRoleWrite)(func(c *gin.Context) {
+		roles, err := mod.GetRoles()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while getting the product"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch roles"})
 			return
 		}
-
-		c.JSON(http.StatusOK, gin.H{"product": product})
+		c.JSON(http.StatusOK, gin.H{"roles": roles})
 	}))
 
-	r.POST("/product", m.AdminAuthenticated(func(c *gin.Context) {
-		var product mod.Product
-		if err := c.ShouldBindJSON(&product); err != nil {
+	r.PUT("/admin/user/:id/roles", m.RequirePermission(mod.PermRoleWrite)(func(c *gin.Context) {
+		var body struct {
+			Roles []string `json:"roles" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 			return
 		}
-		fmt.Println(product.Flavors)
 
-		productID, err := mod.AddProduct(&product)
+		user, err := mod.GetUserByID(c.Param("id"))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add product"})
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{
-			"message":   "Product added successfully",
-			"productID": productID,
-		})
-	}))
 
-	r.PUT("/product/:id", m.AdminAuthenticated(func(c *gin.Context) {
-		id := c.Param("id")
-		if id == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Product ID is required"})
+		err = mod.SetUserRoles(user.ID, body.Roles)
+		if errors.Is(err, mod.ErrUnknownRole) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
 			return
 		}
-
-		var product mod.Product
-		if err := c.ShouldBindJSON(&product); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign roles"})
 			return
 		}
 
-		if err := mod.UpdateProduct(&product); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
-			return
+		// tokens carry the roles, the user has to get new ones
+		if err := controller.RevokeAllUserTokens(user.Email); err != nil {
+			fmt.Println("Error revoking sessions after role change:", err)
 		}
-		c.JSON(http.StatusOK, gin.H{"message": "Product updated successfully"})
+
+		c.JSON(http.StatusOK, gin.H{"message": "Roles updated successfully"})
 	}))
 
-	r.POST("/product/:id/image", func(c *gin.Context) {
-		productID := c.Param("id")
+	r.POST("/user/make-admin/:id", m.RequirePermission(mod.PermRoleWrite)(func(c *gin.Context) {
+		id := c.Param("id")
 
-		// Récupère le fichier image
-		file, err := c.FormFile("image")
+		err := mod.MakeUserAdmin(id)
 		if err != nil {
-			c.JSON(400, gin.H{"error": "Image manquante"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error whihle making the user admin"})
 			return
 		}
 
-		// Répertoire de destination
-		uploadDir := "uploads/"
-		filename := file.Filename
-		path := uploadDir + filename
+		c.JSON(http.StatusOK, gin.H{"message": "the user is now admin !"})
+	}))
 
-		// Vérifie si le fichier existe déjà, et génère un nom unique si besoin
-		i := 1
-		ext := filepath.Ext(filename)
-		name := filename[:len(filename)-len(ext)]
+	r.GET("/user/addresses", m.Authenticated(func(c *gin.Context) {
+		principal := controller.MustGetPrincipal(c)
 
-		for {
-			if _, err := os.Stat(path); os.IsNotExist(err) {
-				break
-			}
-			filename = fmt.Sprintf("%s_%d%s", name, i, ext)
-			path = uploadDir + filename
-			i++
+		addresses, err := mod.GetUserAddresses(principal.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch addresses"})
+			return
 		}
 
-		// Sauvegarde le fichier
-		if err := c.SaveUploadedFile(file, path); err != nil {
-			c.JSON(500, gin.H{"error": "Échec de l'enregistrement de l'image"})
+		c.JSON(http.StatusOK, gin.H{"addresses": addresses})
+	}))
+
+	r.POST("/user/addresses", m.Authenticated(func(c *gin.Context) {
+		var address mod.Address
+		if err := c.ShouldBindJSON(&address); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		address.Normalize()
+		if err := address.Validate(); err != nil {
+			respondAddressError(c, err, "Invalid address")
 			return
 		}
 
-		// Met à jour le chemin en base
-		if err := mod.ChangeImagePath(productID, "/"+path); err != nil {
-			c.JSON(500, gin.H{"error": "Échec de la mise à jour de l'image"})
+		principal := controller.MustGetPrincipal(c)
+
+		if err := mod.AddUserAddress(principal.UserID, &address); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add address"})
 			return
 		}
 
-		c.JSON(200, gin.H{
+		c.JSON(http.StatusCreated, address)
+	}))
+
+	r.PUT("/user/addresses/:id", m.Authenticated(func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:address"})
+			return
+		}
+
+		var address mod.Address
+		if err := c.ShouldBindJSON(&address); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		address.ID = id
+		address.Normalize()
+		if err := address.Validate(); err != nil {
+			respondAddressError(c, err, "Invalid address")
+			return
+		}
+
+		principal := controller.MustGetPrincipal(c)
+
+		if err := mod.UpdateUserAddress(principal.UserID, &address); err != nil {
+			respondAddressError(c, err, "Failed to update address")
+			return
+		}
+
+		c.JSON(http.StatusOK, address)
+	}))
+
+	r.DELETE("/user/addresses/:id", m.Authenticated(func(c *gin.Context) {
+		if _, err := strconv.Atoi(c.Param("id")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:address"})
+			return
+		}
+
+		principal := controller.MustGetPrincipal(c)
+
+		if err := mod.DeleteUserAddress(principal.UserID, c.Param("id")); err != nil {
+			respondAddressError(c, err, "Failed to delete address")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Address deleted successfully"})
+	}))
+
+	r.GET("/user/orders", m.Authenticated(func(c *gin.Context) {
+		principal := controller.MustGetPrincipal(c)
+
+		orders, err := mod.GetAllOrdersFromUser(principal.UserID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "failed to get orders"})
+			return
+		}
+
+		c.JSON(200, gin.H{"orders": orders})
+	}))
+
+	cartLimit := m.RateLimit("cart", "60/1m")
+
+	r.GET("/cart", cartLimit, m.Authenticated(func(c *gin.Context) {
+		principal := controller.MustGetPrincipal(c)
+
+		cart, err := mod.GetCart(principal.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+			return
+		}
+
+		c.JSON(http.StatusOK, cart)
+	}))
+
+	r.POST("/cart/add/", cartLimit, m.Authenticated(func(c *gin.Context) {
+		var prodQuant struct {
+			ProductID string `json:"product_id" binding:"required"`
+			Quantity  int    `json:"quantity" binding:"required,min=1"`
+		}
+
+		if err := c.ShouldBindJSON(&prodQuant); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if prodQuant.ProductID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Product ID is required"})
+			return
+		}
+		if prodQuant.Quantity < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be at least 1"})
+			return
+		}
+
+		if _, err := strconv.Atoi(prodQuant.ProductID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		principal := controller.MustGetPrincipal(c)
+
+		err := mod.AddProductToCart(principal.UserID, prodQuant.ProductID, prodQuant.Quantity)
+		if err != nil {
+			respondCartError(c, err, "Failed to add product to cart")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Product added to cart successfully"})
+	}))
+
+	r.PATCH("/cart/:productId", cartLimit, m.Authenticated(func(c *gin.Context) {
+		productID := c.Param("productId")
+		if _, err := strconv.Atoi(productID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		var body struct {
+			Quantity int `json:"quantity" binding:"required,min=1"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be at least 1"})
+			return
+		}
+
+		principal := controller.MustGetPrincipal(c)
+
+		if err := mod.SetCartQuantity(principal.UserID, productID, body.Quantity); err != nil {
+			respondCartError(c, err, "Failed to update cart")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Cart updated successfully"})
+	}))
+
+	r.DELETE("/cart/:productId", cartLimit, m.Authenticated(func(c *gin.Context) {
+		productID := c.Param("productId")
+		if _, err := strconv.Atoi(productID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		principal := controller.MustGetPrincipal(c)
+
+		if err := mod.RemoveProductFromCart(principal.UserID, productID); err != nil {
+			respondCartError(c, err, "Failed to remove product from cart")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Product removed from cart successfully"})
+	}))
+
+	r.POST("/cart/promo", cartLimit, m.Authenticated(func(c *gin.Context) {
+		var body struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		principal := controller.MustGetPrincipal(c)
+
+		promo, discount, err := mod.ApplyCartPromo(principal.UserID, body.Code)
+		var rejected *mod.PromoRejectedError
+		switch {
+		case errors.As(err, &rejected):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "rejected:promo", "reason": rejected.Reason})
+			return
+		case errors.Is(err, mod.ErrPromoNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:promo"})
+			return
+		case errors.Is(err, mod.ErrCartEmpty):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "empty:cart"})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply promo code"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"code": promo.Code, "discount": discount})
+	}))
+
+	r.DELETE("/cart/promo", cartLimit, m.Authenticated(func(c *gin.Context) {
+		principal := controller.MustGetPrincipal(c)
+
+		if err := mod.RemoveCartPromo(principal.UserID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove promo code"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Promo code removed successfully"})
+	}))
+
+	r.GET("/admin/promo-codes", m.RequirePermission(mod.PermPromoWrite)(func(c *gin.Context) {
+		promos, err := mod.GetPromoCodes()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch promo codes"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"promo_codes": promos})
+	}))
+
+	r.POST("/admin/promo-codes", m.RequirePermission(mod.PermPromoWrite)(func(c *gin.Context) {
+		promo := mod.PromoCode{Active: true}
+		if err := c.ShouldBindJSON(&promo); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		promo.Normalize()
+		if err := promo.Validate(); err != nil {
+			respondPromoError(c, err, "Invalid promo code")
+			return
+		}
+
+		if err := mod.AddPromoCode(&promo); err != nil {
+			respondPromoError(c, err, "Failed to add promo code")
+			return
+		}
+
+		c.JSON(http.StatusCreated, promo)
+	}))
+
+	r.PUT("/admin/promo-codes/:id", m.RequirePermission(mod.PermPromoWrite)(func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:promo"})
+			return
+		}
+
+		var promo mod.PromoCode
+		if err := c.ShouldBindJSON(&promo); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		promo.ID = id
+		promo.Normalize()
+		if err := promo.Validate(); err != nil {
+			respondPromoError(c, err, "Invalid promo code")
+			return
+		}
+
+		if err := mod.UpdatePromoCode(&promo); err != nil {
+			respondPromoError(c, err, "Failed to update promo code")
+			return
+		}
+
+		updated, err := mod.GetPromoCode(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch promo code"})
+			return
+		}
+		c.JSON(http.StatusOK, updated)
+	}))
+
+	r.DELETE("/admin/promo-codes/:id", m.RequirePermission(mod.PermPromoWrite)(func(c *gin.Context) {
+		if _, err := strconv.Atoi(c.Param("id")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:promo"})
+			return
+		}
+
+		if err := mod.DeletePromoCode(c.Param("id")); err != nil {
+			respondPromoError(c, err, "Failed to delete promo code")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Promo code deleted successfully"})
+	}))
+
+	r.DELETE("/cart", cartLimit, m.Authenticated(func(c *gin.Context) {
+		principal := controller.MustGetPrincipal(c)
+
+		if err := mod.ClearCart(principal.UserID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear cart"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Cart cleared successfully"})
+	}))
+
+	r.POST("/order", m.Authenticated(func(c *gin.Context) {
+		// the address is one of the address book, given inline, or by default
+		// the default address
+		var body struct {
+			AddressID int          `json:"address_id"`
+			Address   *mod.Address `json:"address"`
+		}
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+				return
+			}
+		}
+
+		principal := controller.MustGetPrincipal(c)
+
+		address := body.Address
+		if address != nil {
+			address.Normalize()
+			if err := address.Validate(); err != nil {
+				respondAddressError(c, err, "Invalid address")
+				return
+			}
+		} else {
+			var err error
+			if body.AddressID != 0 {
+				address, err = mod.GetUserAddress(principal.UserID, strconv.Itoa(body.AddressID))
+			} else {
+				address, err = mod.GetDefaultAddress(principal.UserID)
+			}
+			if errors.Is(err, mod.ErrAddressNotFound) && body.AddressID == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "required:address"})
+				return
+			}
+			if err != nil {
+				respondAddressError(c, err, "Failed to fetch address")
+				return
+			}
+		}
+
+		order, err := mod.OrderCart(principal.UserID, address)
+		var stockErr *mod.OutOfStockError
+		if errors.As(err, &stockErr) {
+			c.JSON(http.StatusConflict, gin.H{"error": "out-of-stock", "lines": stockErr.Lines})
+			return
+		}
+		if errors.Is(err, mod.ErrCartEmpty) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "empty:cart"})
+			return
+		}
+		var rejected *mod.PromoRejectedError
+		if errors.As(err, &rejected) {
+			c.JSON(http.StatusConflict, gin.H{"error": "rejected:promo", "reason": rejected.Reason})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+			return
+		}
+
+		// the order stays pending when the provider is down, its payment is
+		// started again with POST /order/:id/payment
+		intent, err := controller.StartOrderPayment(c.Request.Context(), order)
+		if err != nil {
+			fmt.Println("Error starting payment:", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "payment-unavailable", "order": order})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Order created successfully", "order": order, "payment": intent})
+	}))
+
+	r.POST("/order/:id/payment", cartLimit, m.Authenticated(func(c *gin.Context) {
+		principal := controller.MustGetPrincipal(c)
+		if _, err := strconv.Atoi(c.Param("id")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:order"})
+			return
+		}
+
+		order, err := mod.GetOrderByID(c.Param("id"))
+		if errors.Is(err, mod.ErrOrderNotFound) || (err == nil && order.CustomerID != principal.UserID) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:order"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+			return
+		}
+		if !mod.CanTransitionOrder(order.Status, mod.OrderPaid) {
+			c.JSON(http.StatusConflict, gin.H{"error": "invalid:status", "status": order.Status})
+			return
+		}
+
+		intent, err := controller.StartOrderPayment(c.Request.Context(), &order.Order)
+		if err != nil {
+			fmt.Println("Error starting payment:", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "payment-unavailable"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"order": order.Order, "payment": intent})
+	}))
+
+	r.POST("/payments/webhook", func(c *gin.Context) {
+		payload, err := c.GetRawData()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		change, err := controller.HandlePaymentWebhook(c.Request.Context(), payload, c.Request.Header)
+		if errors.Is(err, payments.ErrInvalidSignature) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:signature"})
+			return
+		}
+		if err != nil {
+			fmt.Println("Error handling payment webhook:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to handle webhook"})
+			return
+		}
+
+		if change != nil {
+			controller.NotifyOrderStatus(change)
+		}
+		c.JSON(http.StatusOK, gin.H{"received": true})
+	})
+
+	r.GET("/admin/orders", m.RequirePermission(mod.PermOrderRead)(func(c *gin.Context) {
+		filter, invalid := parseOrderFilter(c)
+		if invalid != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:" + invalid})
+			return
+		}
+
+		page, err := mod.ListOrders(*filter)
+		if errors.Is(err, mod.ErrInvalidOrderSort) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:sort"})
+			return
+		}
+		if errors.Is(err, mod.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:cursor"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}))
+
+	r.GET("/admin/order/:id", m.RequirePermission(mod.PermOrderRead)(func(c *gin.Context) {
+		if _, err := strconv.Atoi(c.Param("id")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:order"})
+			return
+		}
+
+		order, err := mod.GetOrderByID(c.Param("id"))
+		if errors.Is(err, mod.ErrOrderNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:order"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+			return
+		}
+
+		c.JSON(http.StatusOK, order)
+	}))
+
+	r.PATCH("/admin/order/:id/status", m.RequirePermission(mod.PermOrderWrite)(func(c *gin.Context) {
+		if _, err := strconv.Atoi(c.Param("id")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:order"})
+			return
+		}
+
+		var body struct {
+			Status string `json:"status" binding:"required"`
+			Note   string `json:"note"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		principal := controller.MustGetPrincipal(c)
+
+		// the money goes back before the order is marked refunded, so that a
+		// failed refund can be retried
+		if body.Status == mod.OrderRefunded {
+			order, err := mod.GetOrderByID(c.Param("id"))
+			if errors.Is(err, mod.ErrOrderNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not-found:order"})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order status"})
+				return
+			}
+			if !mod.CanTransitionOrder(order.Status, body.Status) {
+				c.JSON(http.StatusConflict, gin.H{"error": "invalid:transition", "from": order.Status, "to": body.Status})
+				return
+			}
+			err = controller.RefundOrderPayment(c.Request.Context(), order)
+			if errors.Is(err, mod.ErrRefundInProgress) {
+				c.JSON(http.StatusConflict, gin.H{"error": "refund-in-progress"})
+				return
+			}
+			if err != nil {
+				fmt.Println("Error refunding order:", err)
+				c.JSON(http.StatusBadGateway, gin.H{"error": "refund-failed"})
+				return
+			}
+		}
+
+		change, err := mod.ChangeOrderStatus(c.Param("id"), body.Status, principal.UserID, body.Note)
+		var transitionErr *mod.InvalidTransitionError
+		switch {
+		case errors.As(err, &transitionErr):
+			c.JSON(http.StatusConflict, gin.H{"error": "invalid:transition", "from": transitionErr.From, "to": transitionErr.To})
+			return
+		case errors.Is(err, mod.ErrUnknownOrderStatus):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:status"})
+			return
+		case errors.Is(err, mod.ErrOrderNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:order"})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order status"})
+			return
+		}
+
+		controller.NotifyOrderStatus(change)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Order status updated successfully", "change": change})
+	}))
+}
+
+// parseOrderFilter reads the query of GET /admin/orders, returning the name
+// of the first invalid parameter. Dates are either days, "to" being
+// inclusive, or RFC 3339 times.
+func parseOrderFilter(c *gin.Context) (*mod.OrderFilter, string) {
+	filter := &mod.OrderFilter{
+		Status: c.Query("status"),
+		Sort:   c.Query("sort"),
+		Cursor: c.Query("cursor"),
+	}
+	if filter.Status != "" && !mod.IsOrderStatus(filter.Status) {
+		return nil, "status"
+	}
+
+	for _, param := range []string{"from", "to"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t, err = time.ParseInLocation(time.DateOnly, value, time.Local)
+			if err != nil {
+				return nil, param
+			}
+			if param == "to" {
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+		if param == "from" {
+			filter.From = t.Local()
+		} else {
+			filter.To = t.Local()
+		}
+	}
+
+	if user := c.Query("user"); user != "" {
+		if _, err := strconv.Atoi(user); err != nil {
+			return nil, "user"
+		}
+		filter.UserID = user
+	}
+
+	if minTotal := c.Query("min_total"); minTotal != "" {
+		total, err := money.Parse(minTotal)
+		if err != nil {
+			return nil, "min_total"
+		}
+		filter.MinTotal = &total
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return nil, "limit"
+		}
+		filter.Limit = n
+	}
+
+	return filter, ""
+}
+
+// queryList returns the values of a query parameter, given repeated or
+// comma separated
+func queryList(c *gin.Context, name string) []string {
+	var values []string
+	for _, param := range c.QueryArray(name) {
+		for _, value := range strings.Split(param, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// parseCatalogFilter reads the catalog filter from the query, returning the
+// name of the first invalid parameter if any
+func parseCatalogFilter(c *gin.Context) (*mod.CatalogFilter, string) {
+	filter := &mod.CatalogFilter{
+		Types:    queryList(c, "type"),
+		Genetics: queryList(c, "genetics"),
+		Sort:     c.Query("sort"),
+		Cursor:   c.Query("cursor"),
+	}
+
+	rates := []struct {
+		param string
+		dest  **float64
+	}{
+		{"min_thc", &filter.MinThc},
+		{"max_thc", &filter.MaxThc},
+		{"min_cbd", &filter.MinCbd},
+		{"max_cbd", &filter.MaxCbd},
+	}
+	for _, rate := range rates {
+		value := c.Query(rate.param)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || n < 0 {
+			return nil, rate.param
+		}
+		*rate.dest = &n
+	}
+
+	prices := []struct {
+		param string
+		dest  **money.Money
+	}{
+		{"min_price", &filter.MinPrice},
+		{"max_price", &filter.MaxPrice},
+	}
+	for _, price := range prices {
+		value := c.Query(price.param)
+		if value == "" {
+			continue
+		}
+		amount, err := money.Parse(value)
+		if err != nil {
+			return nil, price.param
+		}
+		*price.dest = &amount
+	}
+
+	if star := c.Query("star"); star != "" {
+		b, err := strconv.ParseBool(star)
+		if err != nil {
+			return nil, "star"
+		}
+		filter.Star = &b
+	}
+	if inStock := c.Query("in_stock"); inStock != "" {
+		b, err := strconv.ParseBool(inStock)
+		if err != nil {
+			return nil, "in_stock"
+		}
+		filter.InStock = b
+	}
+
+	terms := []struct {
+		param string
+		dest  *[]int64
+	}{
+		{"flavor", &filter.Flavors},
+		{"aspect", &filter.Aspects},
+		{"effect", &filter.Effects},
+		{"ideal_for", &filter.IdealFors},
+		{"category", &filter.Categories},
+	}
+	for _, term := range terms {
+		for _, value := range queryList(c, term.param) {
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, term.param
+			}
+			*term.dest = append(*term.dest, id)
+		}
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return nil, "limit"
+		}
+		filter.Limit = n
+	}
+
+	return filter, ""
+}
+
+// respondAddressError maps the address errors of the model to their response
+func respondAddressError(c *gin.Context, err error, message string) {
+	var addressErr *mod.AddressError
+	switch {
+	case errors.As(err, &addressErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:" + addressErr.Field})
+	case errors.Is(err, mod.ErrAddressNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not-found:address"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// respondPromoError maps the promo code errors of the model to their response
+func respondPromoError(c *gin.Context, err error, message string) {
+	var promoErr *mod.PromoError
+	switch {
+	case errors.As(err, &promoErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:" + promoErr.Field})
+	case errors.Is(err, mod.ErrPromoCodeTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "already-used:code"})
+	case errors.Is(err, mod.ErrPromoNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not-found:promo"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// respondTaxonomyError maps the taxonomy errors of the model to their response
+func respondTaxonomyError(c *gin.Context, err error, message string) {
+	var termErr *mod.TermError
+	switch {
+	case errors.As(err, &termErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:" + termErr.Field})
+	case errors.Is(err, mod.ErrTermNameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "already-used:name"})
+	case errors.Is(err, mod.ErrMergeIntoSelf):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:into"})
+	case errors.Is(err, mod.ErrUnknownTaxonomy):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not-found:taxonomy"})
+	case errors.Is(err, mod.ErrTermNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not-found:term"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// respondCartError maps the cart errors of the model to their response
+func respondCartError(c *gin.Context, err error, message string) {
+	var stockErr *mod.InsufficientStockError
+	switch {
+	case errors.As(err, &stockErr):
+		c.JSON(http.StatusConflict, gin.H{"error": "insufficient:stock", "stock": stockErr})
+	case errors.Is(err, mod.ErrProductNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not-found:product"})
+	case errors.Is(err, mod.ErrCartItemNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not-found:cart-item"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+func respondTooManyAttempts(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, try again later"})
+}
+
+// respondWithSession opens a session for a user who passed every login step
+func respondWithSession(c *gin.Context, user *mod.User) {
+	tokens, err := controller.EncodeJWT(user.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	enrollmentRequired, err := controller.IsMFAEnrollmentRequired(user.ID, user.IsAdmin)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting the user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":                   tokens.AccessToken,
+		"refresh_token":           tokens.RefreshToken,
+		"expires_in":              tokens.ExpiresIn,
+		"username":                user.Username,
+		"is_admin":                user.IsAdmin,
+		"mfa_enrollment_required": enrollmentRequired,
+	})
+}
+
+func initProductRoutes(r *gin.Engine) {
+	catalogLimit := m.RateLimit("catalog", "120/1m")
+
+	r.GET("/catalog", catalogLimit, m.OptionalAuthenticated(func(c *gin.Context) {
+		filter, invalid := parseCatalogFilter(c)
+		if invalid != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:" + invalid})
+			return
+		}
+
+		page, err := mod.ListCatalog(*filter)
+		if errors.Is(err, mod.ErrInvalidCatalogSort) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:sort"})
+			return
+		}
+		if errors.Is(err, mod.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:cursor"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}))
+
+	r.GET("/product/search", m.RateLimit("search", "60/1m"), m.OptionalAuthenticated(func(c *gin.Context) {
+		text := strings.TrimSpace(c.Query("q"))
+		if text == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "required:q"})
+			return
+		}
+		if len(text) > mod.MaxSearchLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:q"})
+			return
+		}
+
+		filter, invalid := parseCatalogFilter(c)
+		if invalid != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:" + invalid})
+			return
+		}
+
+		page, err := mod.SearchCatalog(text, *filter)
+		if errors.Is(err, mod.ErrInvalidCatalogSort) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:sort"})
+			return
+		}
+		if errors.Is(err, mod.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:cursor"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}))
+
+	r.GET("/product", m.RequirePermission(mod.PermProductWrite)(func(c *gin.Context) {
+		products, err := mod.GetProducts()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+			return
+		}
+		c.JSON(http.StatusOK, products)
+	}))
+
+	r.GET("/product/:id", catalogLimit, m.OptionalAuthenticated(func(c *gin.Context) {
+		id := c.Param("id")
+		if _, err := strconv.Atoi(id); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:product"})
+			return
+		}
+
+		product, err := mod.GetProductByID(id)
+		if errors.Is(err, mod.ErrProductNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:product"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while getting the product"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"product": product})
+	}))
+
+	r.POST("/product", m.RequirePermission(mod.PermProductWrite)(func(c *gin.Context) {
+		var product mod.Product
+		if err := c.ShouldBindJSON(&product); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		fmt.Println(product.Flavors)
+
+		productID, err := mod.AddProduct(&product)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add product"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "Product added successfully",
+			"productID": productID,
+		})
+	}))
+
+	r.PUT("/product/:id", m.RequirePermission(mod.PermProductWrite)(func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:product"})
+			return
+		}
+
+		var product mod.Product
+		if err := c.ShouldBindJSON(&product); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		product.ID = id
+
+		updateProduct(c, &product)
+	}))
+
+	// PATCH only changes the fields of the body, a taxonomy list given
+	// replacing the current one
+	r.PATCH("/product/:id", m.RequirePermission(mod.PermProductWrite)(func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:product"})
+			return
+		}
+
+		product, err := mod.GetProductByID(c.Param("id"))
+		if errors.Is(err, mod.ErrProductNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:product"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while getting the product"})
+			return
+		}
+
+		if err := c.ShouldBindJSON(product); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		product.ID = id
+
+		updateProduct(c, product)
+	}))
+
+	r.POST("/product/:id/image", m.RateLimit("upload", "20/1h"), m.RequirePermission(mod.PermProductWrite)(func(c *gin.Context) {
+		productID := c.Param("id")
+
+		// Récupère le fichier image
+		file, err := c.FormFile("image")
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Image manquante"})
+			return
+		}
+
+		// Répertoire de destination
+		uploadDir := "uploads/"
+		filename := file.Filename
+		path := uploadDir + filename
+
+		// Vérifie si le fichier existe déjà, et génère un nom unique si besoin
+		i := 1
+		ext := filepath.Ext(filename)
+		name := filename[:len(filename)-len(ext)]
+
+		for {
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				break
+			}
+			filename = fmt.Sprintf("%s_%d%s", name, i, ext)
+			path = uploadDir + filename
+			i++
+		}
+
+		// Sauvegarde le fichier
+		if err := c.SaveUploadedFile(file, path); err != nil {
+			c.JSON(500, gin.H{"error": "Échec de l'enregistrement de l'image"})
+			return
+		}
+
+		// Met à jour le chemin en base
+		if err := mod.ChangeImagePath(productID, "/"+path); err != nil {
+			c.JSON(500, gin.H{"error": "Échec de la mise à jour de l'image"})
+			return
+		}
+
+		c.JSON(200, gin.H{
 			"message": "Image mise à jour avec succès",
 			"path":    "/" + path,
 		})
-	})
+	}))
 
-	r.DELETE("/product/:id", m.AdminAuthenticated(func(c *gin.Context) {
+	r.DELETE("/product/:id", m.RequirePermission(mod.PermProductWrite)(func(c *gin.Context) {
 		id := c.Param("id")
 		if id == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Product ID is required"})
@@ -482,6 +1724,154 @@ func initProductRoutes(r *gin.Engine) {
 	}))
 }
 
+// updateProduct validates and saves the whole product, then responds with
+// it as stored
+func updateProduct(c *gin.Context, product *mod.Product) {
+	var productErr *mod.ProductError
+	err := product.Validate()
+	if err == nil {
+		err = mod.UpdateProduct(product)
+	}
+	switch {
+	case errors.As(err, &productErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid:" + productErr.Field})
+		return
+	case errors.Is(err, mod.ErrProductNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not-found:product"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+		return
+	}
+
+	updated, err := mod.GetProductByID(strconv.Itoa(product.ID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error while getting the product"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Product updated successfully", "product": updated})
+}
+
+func initTaxonomyRoutes(r *gin.Engine) {
+	taxonomyLimit := m.RateLimit("taxonomy", "120/1m")
+
+	// every taxonomy at once, for the filters of the storefront
+	r.GET("/taxonomies", taxonomyLimit, m.OptionalAuthenticated(func(c *gin.Context) {
+		facets := gin.H{}
+		for _, name := range mod.Taxonomies {
+			terms, err := mod.GetTaxonomyTerms(name)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch taxonomies"})
+				return
+			}
+			facets[name] = terms
+		}
+		c.JSON(http.StatusOK, facets)
+	}))
+
+	r.GET("/taxonomies/:taxonomy", taxonomyLimit, m.OptionalAuthenticated(func(c *gin.Context) {
+		terms, err := mod.GetTaxonomyTerms(c.Param("taxonomy"))
+		if err != nil {
+			respondTaxonomyError(c, err, "Failed to fetch taxonomy")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"terms": terms})
+	}))
+
+	r.POST("/admin/taxonomies/:taxonomy", m.RequirePermission(mod.PermProductWrite)(func(c *gin.Context) {
+		var term mod.TaxonomyTerm
+		if err := c.ShouldBindJSON(&term); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		term.Name = mod.NormalizeTermName(term.Name)
+		if err := mod.ValidateTermName(term.Name); err != nil {
+			respondTaxonomyError(c, err, "Invalid term")
+			return
+		}
+
+		if err := mod.AddTaxonomyTerm(c.Param("taxonomy"), &term); err != nil {
+			respondTaxonomyError(c, err, "Failed to add term")
+			return
+		}
+		c.JSON(http.StatusCreated, term)
+	}))
+
+	r.PUT("/admin/taxonomies/:taxonomy/:id", m.RequirePermission(mod.PermProductWrite)(func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:term"})
+			return
+		}
+
+		var term mod.TaxonomyTerm
+		if err := c.ShouldBindJSON(&term); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		term.ID = id
+		term.Name = mod.NormalizeTermName(term.Name)
+		if err := mod.ValidateTermName(term.Name); err != nil {
+			respondTaxonomyError(c, err, "Invalid term")
+			return
+		}
+
+		if err := mod.RenameTaxonomyTerm(c.Param("taxonomy"), &term); err != nil {
+			respondTaxonomyError(c, err, "Failed to rename term")
+			return
+		}
+
+		updated, err := mod.GetTaxonomyTerm(c.Param("taxonomy"), c.Param("id"))
+		if err != nil {
+			respondTaxonomyError(c, err, "Failed to fetch term")
+			return
+		}
+		c.JSON(http.StatusOK, updated)
+	}))
+
+	r.DELETE("/admin/taxonomies/:taxonomy/:id", m.RequirePermission(mod.PermProductWrite)(func(c *gin.Context) {
+		if _, err := strconv.Atoi(c.Param("id")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:term"})
+			return
+		}
+
+		if err := mod.DeleteTaxonomyTerm(c.Param("taxonomy"), c.Param("id")); err != nil {
+			respondTaxonomyError(c, err, "Failed to delete term")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Term deleted successfully"})
+	}))
+
+	// merges the term of the path into the one of the body
+	r.POST("/admin/taxonomies/:taxonomy/:id/merge", m.RequirePermission(mod.PermProductWrite)(func(c *gin.Context) {
+		if _, err := strconv.Atoi(c.Param("id")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not-found:term"})
+			return
+		}
+
+		var body struct {
+			Into int `json:"into" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "required:into"})
+			return
+		}
+
+		into := strconv.Itoa(body.Into)
+		if err := mod.MergeTaxonomyTerms(c.Param("taxonomy"), c.Param("id"), into); err != nil {
+			respondTaxonomyError(c, err, "Failed to merge terms")
+			return
+		}
+
+		merged, err := mod.GetTaxonomyTerm(c.Param("taxonomy"), into)
+		if err != nil {
+			respondTaxonomyError(c, err, "Failed to fetch term")
+			return
+		}
+		c.JSON(http.StatusOK, merged)
+	}))
+}
+
 func initAdminRoutes(r *gin.Engine) {
 	r.GET("/admin", m.AdminAuthenticated(func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "Welcome Admin!"})
@@ -507,7 +1897,7 @@ func initFAQRoutes(r *gin.Engine) {
 		c.JSON(http.StatusOK, faq)
 	}))
 
-	r.POST("/faq", m.AdminAuthenticated(func(c *gin.Context) {
+	r.POST("/faq", m.RequirePermission(mod.PermFAQWrite)(func(c *gin.Context) {
 		var faq struct {
 			Question string `json:"question" binding:"required"`
 			Answer   string `json:"answer" binding:"required"`
@@ -525,7 +1915,7 @@ func initFAQRoutes(r *gin.Engine) {
 		c.JSON(http.StatusOK, gin.H{"message": "FAQ added successfully"})
 	}))
 
-	r.PUT("/faq/:id", m.AdminAuthenticated(func(c *gin.Context) {
+	r.PUT("/faq/:id", m.RequirePermission(mod.PermFAQWrite)(func(c *gin.Context) {
 		faqID := c.Param("id")
 		var faq struct {
 			Question string `json:"question" binding:"required"`
@@ -546,7 +1936,7 @@ func initFAQRoutes(r *gin.Engine) {
 
 	}))
 
-	r.DELETE("/faq/:id", m.AdminAuthenticated(func(c *gin.Context) {
+	r.DELETE("/faq/:id", m.RequirePermission(mod.PermFAQWrite)(func(c *gin.Context) {
 		id := c.Param("id")
 		if id == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "FAQ ID is required"})
@@ -563,7 +1953,7 @@ func initFAQRoutes(r *gin.Engine) {
 }
 
 func initLogsRoutes(r *gin.Engine) {
-	r.DELETE("/log/:id", m.AdminAuthenticated(func(c *gin.Context) {
+	r.DELETE("/log/:id", m.RequirePermission(mod.PermLogWrite)(func(c *gin.Context) {
 		id := c.Param("id")
 
 		err := mod.DeleteLogByID(id)
@@ -576,7 +1966,7 @@ func initLogsRoutes(r *gin.Engine) {
 	}))
 
 	// Récupérer tous les logs
-	r.GET("/log", m.AdminAuthenticated(func(c *gin.Context) {
+	r.GET("/log", m.RequirePermission(mod.PermLogRead)(func(c *gin.Context) {
 		logs, err := mod.GetAllLogs()
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Impossible de récupérer les logs"})
@@ -12,59 +12,97 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func AdminAuthenticated(handler func(c *gin.Context)) func(c *gin.Context) {
+// Authenticate reads the bearer token of every request once and stores the
+// caller in the context, see controller.GetPrincipal. It never rejects a
+// request itself, the wrappers below do.
+func Authenticate() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if c.GetHeader("Authorization") == "" {
-			c.JSON(401, gin.H{"error": "Unauthorized"})
+		controller.AuthenticateRequest(c)
+		c.Next()
+	}
+}
+
+func AdminAuthenticated(handler func(c *gin.Context)) func(c *gin.Context) {
+	return Authenticated(func(c *gin.Context) {
+		principal, _ := controller.GetPrincipal(c)
+		if !principal.IsStaff() {
+			c.JSON(403, gin.H{"error": "Forbidden"})
 			c.Abort()
 			return
 		}
-		token, isUserAdmin, err := controller.DecodeJWT(strings.Split(c.GetHeader("Authorization"), " ")[1])
-		fmt.Println(isUserAdmin,  token, c.GetHeader("Authorization"))
-		if err != nil || token == nil || !isUserAdmin {
-			c.JSON(403, gin.H{"error": "Forbidden"})
+		if !hasRequiredMFA(principal) {
+			c.JSON(403, gin.H{"error": "mfa-enrollment-required"})
 			c.Abort()
 			return
-		} else {
-			fmt.Println("Ça marche !")
-			handler(c)
-			return
 		}
+		handler(c)
+	})
+}
+
+// RequirePermission wraps a handler so that it only runs for users granted
+// the permission by one of their roles
+func RequirePermission(permission string) func(handler func(c *gin.Context)) func(c *gin.Context) {
+	return func(handler func(c *gin.Context)) func(c *gin.Context) {
+		return Authenticated(func(c *gin.Context) {
+			principal, _ := controller.GetPrincipal(c)
+			allowed, err := mod.RolesHavePermission(principal.Roles, permission)
+			if err != nil || !allowed {
+				c.JSON(403, gin.H{"error": "Forbidden"})
+				c.Abort()
+				return
+			}
+			if !hasRequiredMFA(principal) {
+				c.JSON(403, gin.H{"error": "mfa-enrollment-required"})
+				c.Abort()
+				return
+			}
+			handler(c)
+		})
 	}
 }
 
+func hasRequiredMFA(principal *controller.Principal) bool {
+	required, err := controller.IsMFAEnrollmentRequired(principal.UserID, principal.IsStaff())
+	return err == nil && !required
+}
+
 func Authenticated(handler func(c *gin.Context)) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		if c.GetHeader("Authorization") == "" {
+		if _, ok := controller.GetPrincipal(c); !ok {
+			if err := controller.GetAuthError(c); err != nil {
+				fmt.Println("Error decoding JWT:", err)
+			}
 			c.JSON(401, gin.H{"error": "Unauthorized"})
 			c.Abort()
 			return
 		}
-		token, _, err := controller.DecodeJWT(strings.Split(c.GetHeader("Authorization"), " ")[1])
-		if err != nil || token == nil {
+		handler(c)
+	}
+}
+
+// OptionalAuthenticated lets anonymous requests through, the handler telling
+// them apart with controller.GetPrincipal. A token that was sent but refused
+// still gets a 401, so that the client knows to refresh it.
+func OptionalAuthenticated(handler func(c *gin.Context)) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if err := controller.GetAuthError(c); err != nil {
 			fmt.Println("Error decoding JWT:", err)
 			c.JSON(401, gin.H{"error": "Unauthorized"})
 			c.Abort()
 			return
-		} else {
-			fmt.Println("Ça marche !")
-			handler(c)
-			return
 		}
+		handler(c)
 	}
 }
 
 func LogRequest() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		c.Next()
+
 		userID := "not connected"
-		if c.GetHeader("Authorization") != "" {
-			userMail, err := controller.GetUserEmailFromGinContext(c)
-			if err == nil {
-				user, _ := mod.GetUserByEmailOrUsername(userMail, true)
-				userID = user.ID
-			}
+		if principal, ok := controller.GetPrincipal(c); ok {
+			userID = principal.UserID
 		}
-		c.Next()
 
 		// Enregistre la requête dans la BDD
 		if (strings.Contains("GET POST PUT DELETE", c.Request.Method)) {
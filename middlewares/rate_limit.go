@@ -0,0 +1,154 @@
+package middlewares
+
+import (
+	"log"
+	"math"
+	"os"
+	"sec-app-server/controller"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bucket is a token bucket: it holds up to capacity tokens, refilled
+// continuously, and every request takes one.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	mu        sync.Mutex
+	capacity  float64
+	perSecond float64
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+// RateLimit limits the requests of a route group per client, the
+// authenticated user when there is one and the IP otherwise. The limit is
+// read from RATE_LIMIT_<GROUP> as "<requests>/<period>" (e.g. "10/1m", "off"
+// to disable), falling back to defaultLimit, and the bucket size from
+// RATE_LIMIT_<GROUP>_BURST, by default the number of requests.
+func RateLimit(group, defaultLimit string) gin.HandlerFunc {
+	envName := "RATE_LIMIT_" + strings.ToUpper(group)
+	limit := os.Getenv(envName)
+	if limit == "" {
+		limit = defaultLimit
+	}
+	if limit == "off" {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	requests, period, err := parseRateLimit(limit)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", envName, limit, err)
+	}
+
+	burst := float64(requests)
+	if b := os.Getenv(envName + "_BURST"); b != "" {
+		n, err := strconv.Atoi(b)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid %s_BURST %q", envName, b)
+		}
+		burst = float64(n)
+	}
+
+	limiter := &rateLimiter{
+		capacity:  burst,
+		perSecond: float64(requests) / period.Seconds(),
+		buckets:   map[string]*bucket{},
+	}
+
+	return func(c *gin.Context) {
+		key := "ip:" + c.ClientIP()
+		if principal, ok := controller.GetPrincipal(c); ok {
+			key = "user:" + principal.UserID
+		}
+
+		allowed, remaining, reset, retryAfter := limiter.take(key, time.Now())
+
+		c.Header("RateLimit-Limit", strconv.Itoa(int(limiter.capacity)))
+		c.Header("RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.JSON(429, gin.H{"error": "Too many requests"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func parseRateLimit(limit string) (int, time.Duration, error) {
+	count, per, found := strings.Cut(limit, "/")
+	if !found {
+		return 0, 0, strconv.ErrSyntax
+	}
+
+	requests, err := strconv.Atoi(count)
+	if err != nil || requests < 1 {
+		return 0, 0, strconv.ErrSyntax
+	}
+
+	period, err := time.ParseDuration(per)
+	if err != nil || period <= 0 {
+		return 0, 0, strconv.ErrSyntax
+	}
+	return requests, period, nil
+}
+
+// take consumes a token from the bucket of key. It returns whether the
+// request may go through, the tokens left, the time until the bucket is full
+// again and, when refused, the time until the next token.
+func (l *rateLimiter) take(key string, now time.Time) (bool, int, time.Duration, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.capacity, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = min(l.capacity, b.tokens+now.Sub(b.last).Seconds()*l.perSecond)
+	b.last = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+
+	reset := l.secondsFor(l.capacity - b.tokens)
+	var retryAfter time.Duration
+	if !allowed {
+		retryAfter = l.secondsFor(1 - b.tokens)
+	}
+	return allowed, int(b.tokens), reset, retryAfter
+}
+
+func (l *rateLimiter) secondsFor(tokens float64) time.Duration {
+	return time.Duration(tokens / l.perSecond * float64(time.Second))
+}
+
+// prune drops, once a minute, the buckets that have refilled completely and
+// are therefore the same as a new one
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.perSecond >= l.capacity {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package middlewares
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestLimiter(requests int, period time.Duration, burst int) *rateLimiter {
+	return &rateLimiter{
+		capacity:  float64(burst),
+		perSecond: float64(requests) / period.Seconds(),
+		buckets:   map[string]*bucket{},
+	}
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	l := newTestLimiter(10, time.Minute, 3)
+	now := time.Now()
+
+	for i := range 3 {
+		allowed, remaining, _, _ := l.take("key", now)
+		if !allowed {
+			t.Fatalf("request %d refused within the burst", i+1)
+		}
+		if remaining != 2-i {
+			t.Errorf("remaining = %d, want %d", remaining, 2-i)
+		}
+	}
+
+	allowed, _, _, retryAfter := l.take("key", now)
+	if allowed {
+		t.Fatal("request allowed past the burst")
+	}
+	if retryAfter != 6*time.Second {
+		t.Errorf("retryAfter = %v, want 6s", retryAfter)
+	}
+
+	if allowed, _, _, _ := l.take("other", now); !allowed {
+		t.Error("another key shares the bucket")
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	l := newTestLimiter(10, time.Minute, 2)
+	now := time.Now()
+	l.take("key", now)
+	l.take("key", now)
+
+	if allowed, _, _, _ := l.take("key", now.Add(5*time.Second)); allowed {
+		t.Fatal("allowed before a token was refilled")
+	}
+	if allowed, _, _, _ := l.take("key", now.Add(7*time.Second)); !allowed {
+		t.Fatal("refused once a token was refilled")
+	}
+
+	// a long pause refills up to the burst, not beyond
+	later := now.Add(time.Hour)
+	for i := range 2 {
+		if allowed, _, _, _ := l.take("key", later); !allowed {
+			t.Fatalf("request %d refused after a full refill", i+1)
+		}
+	}
+	if allowed, _, _, _ := l.take("key", later); allowed {
+		t.Error("the bucket refilled beyond its capacity")
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	l := newTestLimiter(10, time.Minute, 2)
+	now := time.Now()
+	l.take("key", now)
+
+	l.take("other", now.Add(2*time.Minute))
+	if _, ok := l.buckets["key"]; ok {
+		t.Error("a full bucket was not pruned")
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	requests, period, err := parseRateLimit("10/1m")
+	if err != nil || requests != 10 || period != time.Minute {
+		t.Errorf("parseRateLimit(10/1m) = %d, %v, %v", requests, period, err)
+	}
+	for _, limit := range []string{"10", "0/1m", "x/1m", "10/0s", "10/soon"} {
+		if _, _, err := parseRateLimit(limit); err == nil {
+			t.Errorf("parseRateLimit(%q) accepted", limit)
+		}
+	}
+}
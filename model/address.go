@@ -0,0 +1,294 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sec-app-server/db"
+	"strings"
+)
+
+var ErrAddressNotFound = errors.New("address not found")
+
+type Address struct {
+	ID         int    `json:"id,omitempty"`
+	Label      string `json:"label"`
+	FullName   string `json:"full_name"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	PostalCode string `json:"postal_code"`
+	City       string `json:"city"`
+	// Country is the ISO 3166-1 alpha-2 code
+	Country   string `json:"country"`
+	Phone     string `json:"phone"`
+	IsDefault bool   `json:"is_default,omitempty"`
+}
+
+// AddressError names the field of an address that is missing or malformed
+type AddressError struct {
+	Field string
+}
+
+func (e *AddressError) Error() string {
+	return "invalid address field: " + e.Field
+}
+
+// postalCodeFormats are the postal code formats of the countries we deliver
+// the most, others only have to look like a postal code
+var postalCodeFormats = map[string]*regexp.Regexp{
+	"FR": regexp.MustCompile(`^\d{5}$`),
+	"DE": regexp.MustCompile(`^\d{5}$`),
+	"ES": regexp.MustCompile(`^\d{5}$`),
+	"IT": regexp.MustCompile(`^\d{5}$`),
+	"BE": regexp.MustCompile(`^\d{4}$`),
+	"CH": regexp.MustCompile(`^\d{4}$`),
+	"LU": regexp.MustCompile(`^\d{4}$`),
+	"NL": regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`),
+}
+
+var (
+	anyPostalCode = regexp.MustCompile(`^[A-Z0-9][A-Z0-9 -]{1,8}[A-Z0-9]$`)
+	countryCode   = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// Normalize trims the fields of the address and upper cases the country and
+// postal code, before Validate
+func (a *Address) Normalize() {
+	for _, field := range []*string{&a.Label, &a.FullName, &a.Line1, &a.Line2, &a.PostalCode, &a.City, &a.Country, &a.Phone} {
+		*field = strings.TrimSpace(*field)
+	}
+	a.Country = strings.ToUpper(a.Country)
+	a.PostalCode = strings.ToUpper(a.PostalCode)
+}
+
+func (a *Address) Validate() error {
+	required := []struct {
+		name  string
+		value string
+	}{
+		{"full_name", a.FullName},
+		{"line1", a.Line1},
+		{"postal_code", a.PostalCode},
+		{"city", a.City},
+		{"country", a.Country},
+	}
+	for _, field := range required {
+		if field.value == "" {
+			return &AddressError{Field: field.name}
+		}
+	}
+
+	if !countryCode.MatchString(a.Country) {
+		return &AddressError{Field: "country"}
+	}
+
+	format, ok := postalCodeFormats[a.Country]
+	if !ok {
+		format = anyPostalCode
+	}
+	if !format.MatchString(a.PostalCode) {
+		return &AddressError{Field: "postal_code"}
+	}
+	return nil
+}
+
+// String is the address on one line, kept in orders.delivery_coordinate
+func (a *Address) String() string {
+	parts := []string{a.FullName, a.Line1}
+	if a.Line2 != "" {
+		parts = append(parts, a.Line2)
+	}
+	parts = append(parts, a.PostalCode+" "+a.City, a.Country)
+	return strings.Join(parts, ", ")
+}
+
+// snapshot is the copy of the address stored with an order, without what
+// only makes sense in the address book
+func (a Address) snapshot() Address {
+	a.ID = 0
+	a.IsDefault = false
+	return a
+}
+
+// addressColumn scans a JSON address snapshot, NULL leaving it nil
+type addressColumn struct {
+	dest **Address
+}
+
+func (c *addressColumn) Scan(src any) error {
+	if src == nil {
+		*c.dest = nil
+		return nil
+	}
+	data, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("unexpected address column type %T", src)
+	}
+	address := &Address{}
+	if err := json.Unmarshal(data, address); err != nil {
+		return err
+	}
+	*c.dest = address
+	return nil
+}
+
+const addressColumns = "id, label, full_name, line1, line2, postal_code, city, country, phone, is_default"
+
+func scanAddress(row interface{ Scan(...any) error }) (*Address, error) {
+	var a Address
+	err := row.Scan(&a.ID, &a.Label, &a.FullName, &a.Line1, &a.Line2, &a.PostalCode, &a.City, &a.Country, &a.Phone, &a.IsDefault)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// GetUserAddresses returns the address book of the user, default first
+func GetUserAddresses(userID string) ([]Address, error) {
+	addresses := []Address{}
+	rows, err := db.DB.Query("SELECT "+addressColumns+" FROM user_addresses WHERE user_id = $1 ORDER BY is_default DESC, id", userID)
+	if err != nil {
+		fmt.Println("Error fetching addresses:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		address, err := scanAddress(rows)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, *address)
+	}
+	return addresses, rows.Err()
+}
+
+func GetUserAddress(userID, addressID string) (*Address, error) {
+	row := db.DB.QueryRow("SELECT "+addressColumns+" FROM user_addresses WHERE user_id = $1 AND id = $2", userID, addressID)
+	address, err := scanAddress(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrAddressNotFound
+	}
+	if err != nil {
+		fmt.Println("Error fetching address:", err)
+		return nil, err
+	}
+	return address, nil
+}
+
+// GetDefaultAddress returns the default address of the user, ErrAddressNotFound
+// if the address book is empty
+func GetDefaultAddress(userID string) (*Address, error) {
+	row := db.DB.QueryRow("SELECT "+addressColumns+" FROM user_addresses WHERE user_id = $1 AND is_default", userID)
+	address, err := scanAddress(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrAddressNotFound
+	}
+	if err != nil {
+		fmt.Println("Error fetching default address:", err)
+		return nil, err
+	}
+	return address, nil
+}
+
+// AddUserAddress adds a validated address to the address book. The first
+// address of a user always becomes the default.
+func AddUserAddress(userID string, address *Address) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM user_addresses WHERE user_id = $1", userID).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		address.IsDefault = true
+	}
+	if address.IsDefault {
+		if _, err := tx.Exec("UPDATE user_addresses SET is_default = false WHERE user_id = $1 AND is_default", userID); err != nil {
+			return err
+		}
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO user_addresses (user_id, label, full_name, line1, line2, postal_code, city, country, phone, is_default)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id
+	`, userID, address.Label, address.FullName, address.Line1, address.Line2, address.PostalCode, address.City, address.Country, address.Phone, address.IsDefault).Scan(&address.ID)
+	if err != nil {
+		fmt.Println("Error adding address:", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateUserAddress replaces an address of the address book. The default can
+// only be moved to another address, not unset.
+func UpdateUserAddress(userID string, address *Address) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if address.IsDefault {
+		if _, err := tx.Exec("UPDATE user_addresses SET is_default = false WHERE user_id = $1 AND is_default AND id <> $2", userID, address.ID); err != nil {
+			return err
+		}
+	}
+
+	err = tx.QueryRow(`
+		UPDATE user_addresses
+		SET label = $3, full_name = $4, line1 = $5, line2 = $6, postal_code = $7, city = $8, country = $9, phone = $10, is_default = is_default OR $11
+		WHERE user_id = $1 AND id = $2
+		RETURNING is_default
+	`, userID, address.ID, address.Label, address.FullName, address.Line1, address.Line2, address.PostalCode, address.City, address.Country, address.Phone, address.IsDefault).Scan(&address.IsDefault)
+	if err == sql.ErrNoRows {
+		return ErrAddressNotFound
+	}
+	if err != nil {
+		fmt.Println("Error updating address:", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteUserAddress removes an address, the oldest remaining one becoming the
+// default if it was. Orders keep their own copy of the address.
+func DeleteUserAddress(userID, addressID string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var wasDefault bool
+	err = tx.QueryRow("DELETE FROM user_addresses WHERE user_id = $1 AND id = $2 RETURNING is_default", userID, addressID).Scan(&wasDefault)
+	if err == sql.ErrNoRows {
+		return ErrAddressNotFound
+	}
+	if err != nil {
+		fmt.Println("Error deleting address:", err)
+		return err
+	}
+
+	if wasDefault {
+		_, err := tx.Exec(`
+			UPDATE user_addresses SET is_default = true
+			WHERE id = (SELECT id FROM user_addresses WHERE user_id = $1 ORDER BY id LIMIT 1)
+		`, userID)
+		if err != nil {
+			fmt.Println("Error moving default address:", err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
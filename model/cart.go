@@ -0,0 +1,231 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sec-app-server/db"
+	"sec-app-server/money"
+	"time"
+)
+
+var (
+	ErrProductNotFound  = errors.New("product not found")
+	ErrCartItemNotFound = errors.New("product is not in the cart")
+)
+
+// InsufficientStockError is returned when a quantity asked for is more than
+// the stock of the product
+type InsufficientStockError struct {
+	ProductID int `json:"product_id"`
+	Requested int `json:"requested"`
+	Available int `json:"available"`
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("product %d: %d requested, %d in stock", e.ProductID, e.Requested, e.Available)
+}
+
+type CartItem struct {
+	Product   *Product    `json:"product"`
+	Quantity  int         `json:"quantity"`
+	UnitPrice money.Money `json:"unit_price"`
+	Total     money.Money `json:"total"`
+}
+
+type Cart struct {
+	Items    []CartItem  `json:"items"`
+	Subtotal money.Money `json:"subtotal"`
+	Promo    *CartPromo  `json:"promo"`
+	Total    money.Money `json:"total"`
+}
+
+// CartPromo is the promo code applied to a cart. Rejected tells why it would
+// not be accepted at checkout anymore, Discount then being 0.
+type CartPromo struct {
+	Code     string              `json:"code"`
+	Discount money.Money         `json:"discount"`
+	Rejected *PromoRejectedError `json:"rejected,omitempty"`
+}
+
+// GetCart returns the lines of the cart priced at the current product prices
+func GetCart(userID string) (*Cart, error) {
+	rows, err := db.DB.Query("SELECT product_id, quantity FROM cart WHERE user_id = $1 ORDER BY product_id", userID)
+	if err != nil {
+		fmt.Println("Error fetching cart items:", err)
+		return nil, err
+	}
+
+	type line struct {
+		productID int
+		quantity  int
+	}
+	var lines []line
+	for rows.Next() {
+		var l line
+		if err := rows.Scan(&l.productID, &l.quantity); err != nil {
+			rows.Close()
+			fmt.Println("Error scanning cart item:", err)
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, len(lines))
+	for i, l := range lines {
+		ids[i] = l.productID
+	}
+	products, err := loadProducts(ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[int]*Product{}
+	for i := range products {
+		byID[products[i].ID] = &products[i]
+	}
+
+	cart := &Cart{Items: []CartItem{}, Subtotal: money.New(0)}
+	for _, l := range lines {
+		product, ok := byID[l.productID]
+		if !ok {
+			continue
+		}
+
+		total := product.Price.Mul(l.quantity)
+		cart.Items = append(cart.Items, CartItem{
+			Product:   product,
+			Quantity:  l.quantity,
+			UnitPrice: product.Price,
+			Total:     total,
+		})
+		cart.Subtotal = cart.Subtotal.Add(total)
+	}
+	cart.Total = cart.Subtotal
+
+	promo, err := cartPromo(db.DB, userID, false)
+	if err != nil {
+		return nil, err
+	}
+	if promo != nil && len(cart.Items) > 0 {
+		priced := make([]pricedLine, len(cart.Items))
+		for i, item := range cart.Items {
+			priced[i] = pricedLine{productID: item.Product.ID, price: item.UnitPrice, quantity: item.Quantity}
+		}
+
+		cart.Promo = &CartPromo{Code: promo.Code}
+		discount, err := promoDiscount(db.DB, promo, userID, priced, time.Now())
+		var rejected *PromoRejectedError
+		if errors.As(err, &rejected) {
+			cart.Promo.Rejected = rejected
+		} else if err != nil {
+			return nil, err
+		}
+		cart.Promo.Discount = discount
+		cart.Total = cart.Subtotal.Sub(discount)
+	}
+
+	return cart, nil
+}
+
+// AddProductToCart adds quantity to the cart line of the product, creating
+// it if needed, as long as the stock covers the new quantity
+func AddProductToCart(userID, productID string, quantity int) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stock, err := productStock(tx, productID)
+	if err != nil {
+		return err
+	}
+
+	var total int
+	err = tx.QueryRow(`
+		INSERT INTO cart (user_id, product_id, quantity) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, product_id) DO UPDATE SET quantity = cart.quantity + EXCLUDED.quantity
+		RETURNING quantity
+	`, userID, productID, quantity).Scan(&total)
+	if err != nil {
+		fmt.Println("Error executing add to cart statement:", err)
+		return err
+	}
+
+	if total > stock.available {
+		return &InsufficientStockError{ProductID: stock.productID, Requested: total, Available: stock.available}
+	}
+
+	return tx.Commit()
+}
+
+// SetCartQuantity replaces the quantity of a product already in the cart
+func SetCartQuantity(userID, productID string, quantity int) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stock, err := productStock(tx, productID)
+	if err != nil {
+		return err
+	}
+	if quantity > stock.available {
+		return &InsufficientStockError{ProductID: stock.productID, Requested: quantity, Available: stock.available}
+	}
+
+	res, err := tx.Exec("UPDATE cart SET quantity = $3 WHERE user_id = $1 AND product_id = $2", userID, productID, quantity)
+	if err != nil {
+		fmt.Println("Error updating cart item:", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrCartItemNotFound
+	}
+
+	return tx.Commit()
+}
+
+func RemoveProductFromCart(userID, productID string) error {
+	res, err := db.DB.Exec("DELETE FROM cart WHERE user_id = $1 AND product_id = $2", userID, productID)
+	if err != nil {
+		fmt.Println("Error removing cart item:", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrCartItemNotFound
+	}
+	return nil
+}
+
+func ClearCart(userID string) error {
+	_, err := db.DB.Exec("DELETE FROM cart WHERE user_id = $1", userID)
+	if err != nil {
+		fmt.Println("Error clearing cart:", err)
+		return err
+	}
+	return RemoveCartPromo(userID)
+}
+
+type stockLevel struct {
+	productID int
+	available int
+}
+
+func productStock(tx *sql.Tx, productID string) (*stockLevel, error) {
+	var stock stockLevel
+	err := tx.QueryRow("SELECT id, stock FROM product WHERE id = $1", productID).Scan(&stock.productID, &stock.available)
+	if err == sql.ErrNoRows {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		fmt.Println("Error fetching product stock:", err)
+		return nil, err
+	}
+	return &stock, nil
+}
@@ -0,0 +1,221 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sec-app-server/db"
+	"sec-app-server/money"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const (
+	DefaultCatalogPageSize = 24
+	MaxCatalogPageSize     = 100
+)
+
+var ErrInvalidCatalogSort = errors.New("invalid sort")
+
+// catalogSort is a column the catalog can be ordered by, ties being broken
+// by the product id in the same direction
+type catalogSort struct {
+	column     string
+	descending bool
+	value      cursorValue
+}
+
+var catalogSorts = map[string]catalogSort{
+	"newest":  {"p.id", true, cursorInteger},
+	"price":   {"p.price", false, cursorNumber},
+	"-price":  {"p.price", true, cursorNumber},
+	"rating":  {"p.rating", false, cursorNumber},
+	"-rating": {"p.rating", true, cursorNumber},
+}
+
+// CatalogFilter selects the products listed by ListCatalog. Zero values do
+// not filter, several values of a list match any of them.
+type CatalogFilter struct {
+	Types    []string
+	Genetics []string
+	MinThc   *float64
+	MaxThc   *float64
+	MinCbd   *float64
+	MaxCbd   *float64
+	MinPrice *money.Money
+	MaxPrice *money.Money
+	Star     *bool
+	InStock  bool
+	// taxonomy term ids
+	Flavors    []int64
+	Aspects    []int64
+	Effects    []int64
+	IdealFors  []int64
+	Categories []int64
+	// Sort is "newest", the default, "price", "-price", "rating" or "-rating"
+	Sort   string
+	Cursor string
+	Limit  int
+}
+
+type CatalogPage struct {
+	Products   []Product `json:"products"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// productQuery builds the WHERE clause of a product query, every value being
+// passed as a parameter
+type productQuery struct {
+	conditions []string
+	args       []any
+}
+
+func (q *productQuery) arg(value any) string {
+	q.args = append(q.args, value)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// where adds a condition, each %s of format being replaced by the parameter
+// of the matching value
+func (q *productQuery) where(format string, values ...any) {
+	params := make([]any, len(values))
+	for i, value := range values {
+		params[i] = q.arg(value)
+	}
+	q.conditions = append(q.conditions, fmt.Sprintf(format, params...))
+}
+
+func (q *productQuery) whereAnyTerm(t taxonomy, ids []int64) {
+	if len(ids) == 0 {
+		return
+	}
+	q.where("EXISTS (SELECT 1 FROM "+t.link+" t WHERE t.product_id = p.id AND t."+t.column+" = ANY(%s))", pq.Array(ids))
+}
+
+func (q *productQuery) whereClause() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+	return "\n\t\tWHERE " + strings.Join(q.conditions, " AND ")
+}
+
+func (q *productQuery) filter(filter CatalogFilter) {
+	if len(filter.Types) > 0 {
+		q.where("p.type = ANY(%s)", pq.Array(filter.Types))
+	}
+	if len(filter.Genetics) > 0 {
+		q.where("p.genetics = ANY(%s)", pq.Array(filter.Genetics))
+	}
+	if filter.MinThc != nil {
+		q.where("p.thc_rate >= %s", *filter.MinThc)
+	}
+	if filter.MaxThc != nil {
+		q.where("p.thc_rate <= %s", *filter.MaxThc)
+	}
+	if filter.MinCbd != nil {
+		q.where("p.cbd_rate >= %s", *filter.MinCbd)
+	}
+	if filter.MaxCbd != nil {
+		q.where("p.cbd_rate <= %s", *filter.MaxCbd)
+	}
+	if filter.MinPrice != nil {
+		q.where("p.price >= %s", filter.MinPrice.String())
+	}
+	if filter.MaxPrice != nil {
+		q.where("p.price <= %s", filter.MaxPrice.String())
+	}
+	if filter.Star != nil {
+		q.where("p.star = %s", *filter.Star)
+	}
+	if filter.InStock {
+		q.conditions = append(q.conditions, "p.stock > 0")
+	}
+	q.whereAnyTerm(flavorTaxonomy, filter.Flavors)
+	q.whereAnyTerm(aspectTaxonomy, filter.Aspects)
+	q.whereAnyTerm(effectTaxonomy, filter.Effects)
+	q.whereAnyTerm(idealForTaxonomy, filter.IdealFors)
+	q.whereAnyTerm(categoryTaxonomy, filter.Categories)
+}
+
+// ListCatalog returns a page of the products matching the filter
+func ListCatalog(filter CatalogFilter) (*CatalogPage, error) {
+	q := &productQuery{}
+	q.filter(filter)
+
+	ids, nextCursor, err := pageProductIDs(q, filter, catalogSorts, "newest", "")
+	if err != nil {
+		return nil, err
+	}
+
+	page := &CatalogPage{NextCursor: nextCursor}
+	page.Products, err = loadProducts(ids)
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// pageProductIDs returns the ids of the products of q in the page the
+// cursor and sort of the filter select, with the cursor of the next page.
+// The cursors of a search only work with the same search text.
+func pageProductIDs(q *productQuery, filter CatalogFilter, sorts map[string]catalogSort, defaultSort, searchText string) ([]int, string, error) {
+	sortName := filter.Sort
+	if sortName == "" {
+		sortName = defaultSort
+	}
+	sort, ok := sorts[sortName]
+	if !ok {
+		return nil, "", ErrInvalidCatalogSort
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultCatalogPageSize
+	}
+	limit = min(limit, MaxCatalogPageSize)
+
+	operator, direction := ">", "ASC"
+	if sort.descending {
+		operator, direction = "<", "DESC"
+	}
+	if filter.Cursor != "" {
+		cursor, err := decodeCursor(filter.Cursor, sortName, searchText, sort.value)
+		if err != nil {
+			return nil, "", err
+		}
+		q.where("("+sort.column+", p.id) "+operator+" (%s, %s)", cursor.Value, cursor.ID)
+	}
+
+	query := `
+		SELECT p.id, (` + sort.column + `)::TEXT
+		FROM product p` + q.whereClause()
+	query += fmt.Sprintf("\n\t\tORDER BY %s %s, p.id %s\n\t\tLIMIT %s", sort.column, direction, direction, q.arg(limit+1))
+
+	rows, err := db.DB.Query(query, q.args...)
+	if err != nil {
+		fmt.Println("Error listing products:", err)
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var ids []int
+	var sortValues []string
+	for rows.Next() {
+		var id int
+		var sortValue string
+		if err := rows.Scan(&id, &sortValue); err != nil {
+			return nil, "", err
+		}
+		ids = append(ids, id)
+		sortValues = append(sortValues, sortValue)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if len(ids) <= limit {
+		return ids, "", nil
+	}
+	ids = ids[:limit]
+	return ids, encodeCursor(pageCursor{Sort: sortName, Query: queryDigest(searchText), Value: sortValues[limit-1], ID: ids[limit-1]}), nil
+}
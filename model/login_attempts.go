@@ -0,0 +1,179 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"sec-app-server/db"
+	"sync"
+	"time"
+)
+
+// LoginAttempts is the failure record of one throttling key (an account or
+// a client IP).
+type LoginAttempts struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// LoginAttemptStore keeps the failed login counters. Update applies fn to the
+// record of the key atomically and returns the result.
+type LoginAttemptStore interface {
+	Get(key string) (LoginAttempts, error)
+	Update(key string, fn func(*LoginAttempts)) (LoginAttempts, error)
+	Reset(key string) error
+}
+
+// PostgresLoginAttemptStore is the LoginAttemptStore used in production,
+// shared by every instance and kept across restarts. Counters are forgotten
+// after a day without failure.
+type PostgresLoginAttemptStore struct {
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+const loginAttemptsRetention = 24 * time.Hour
+
+func (s *PostgresLoginAttemptStore) Get(key string) (LoginAttempts, error) {
+	var attempts LoginAttempts
+	err := db.DB.QueryRow("SELECT failures, last_failure, locked_until FROM login_attempts WHERE key = $1", key).
+		Scan(&attempts.Failures, &attempts.LastFailure, &attempts.LockedUntil)
+	if err == sql.ErrNoRows {
+		return LoginAttempts{}, nil
+	}
+	if err != nil {
+		fmt.Println("Error fetching login attempts:", err)
+		return LoginAttempts{}, err
+	}
+	return attempts, nil
+}
+
+func (s *PostgresLoginAttemptStore) Update(key string, fn func(*LoginAttempts)) (LoginAttempts, error) {
+	s.prune(time.Now())
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return LoginAttempts{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO login_attempts (key, failures, last_failure, locked_until) VALUES ($1, 0, 'epoch', 'epoch')
+		ON CONFLICT (key) DO NOTHING
+	`, key)
+	if err != nil {
+		fmt.Println("Error creating login attempts:", err)
+		return LoginAttempts{}, err
+	}
+
+	var attempts LoginAttempts
+	err = tx.QueryRow("SELECT failures, last_failure, locked_until FROM login_attempts WHERE key = $1 FOR UPDATE", key).
+		Scan(&attempts.Failures, &attempts.LastFailure, &attempts.LockedUntil)
+	if err != nil {
+		fmt.Println("Error fetching login attempts:", err)
+		return LoginAttempts{}, err
+	}
+	if expired(attempts, time.Now()) {
+		attempts = LoginAttempts{}
+	}
+
+	fn(&attempts)
+	_, err = tx.Exec("UPDATE login_attempts SET failures = $2, last_failure = $3, locked_until = $4 WHERE key = $1",
+		key, attempts.Failures, attempts.LastFailure, attempts.LockedUntil)
+	if err != nil {
+		fmt.Println("Error updating login attempts:", err)
+		return LoginAttempts{}, err
+	}
+
+	return attempts, tx.Commit()
+}
+
+func (s *PostgresLoginAttemptStore) Reset(key string) error {
+	_, err := db.DB.Exec("DELETE FROM login_attempts WHERE key = $1", key)
+	if err != nil {
+		fmt.Println("Error resetting login attempts:", err)
+	}
+	return err
+}
+
+// prune deletes, at most once a minute, the counters that have expired
+func (s *PostgresLoginAttemptStore) prune(now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastPrune) < time.Minute {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPrune = now
+	s.mu.Unlock()
+
+	_, err := db.DB.Exec("DELETE FROM login_attempts WHERE last_failure < $1 AND locked_until < $2", now.Add(-loginAttemptsRetention), now)
+	if err != nil {
+		fmt.Println("Error purging login attempts:", err)
+	}
+}
+
+// expired tells whether the counter is old enough to be forgotten
+func expired(a LoginAttempts, now time.Time) bool {
+	return now.Sub(a.LastFailure) > loginAttemptsRetention && now.After(a.LockedUntil)
+}
+
+// MemoryLoginAttemptStore is an in-process LoginAttemptStore, meant for
+// tests and single instance development servers.
+type MemoryLoginAttemptStore struct {
+	mu        sync.Mutex
+	attempts  map[string]LoginAttempts
+	lastPrune time.Time
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{attempts: map[string]LoginAttempts{}}
+}
+
+func (s *MemoryLoginAttemptStore) Get(key string) (LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts := s.attempts[key]
+	if expired(attempts, time.Now()) {
+		return LoginAttempts{}, nil
+	}
+	return attempts, nil
+}
+
+func (s *MemoryLoginAttemptStore) Update(key string, fn func(*LoginAttempts)) (LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.prune(now)
+
+	attempts := s.attempts[key]
+	if expired(attempts, now) {
+		attempts = LoginAttempts{}
+	}
+	fn(&attempts)
+	s.attempts[key] = attempts
+	return attempts, nil
+}
+
+func (s *MemoryLoginAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// prune drops, once a minute, the counters that have expired
+func (s *MemoryLoginAttemptStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < time.Minute {
+		return
+	}
+	s.lastPrune = now
+
+	for key, a := range s.attempts {
+		if expired(a, now) {
+			delete(s.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryLoginAttemptStore(t *testing.T) {
+	s := NewMemoryLoginAttemptStore()
+	increment := func(a *LoginAttempts) {
+		a.Failures++
+		a.LastFailure = time.Now()
+	}
+
+	s.Update("key", increment)
+	attempts, _ := s.Update("key", increment)
+	if attempts.Failures != 2 {
+		t.Fatalf("Failures = %d, want 2", attempts.Failures)
+	}
+	if got, _ := s.Get("other"); got.Failures != 0 {
+		t.Errorf("other key has %d failures", got.Failures)
+	}
+
+	s.Reset("key")
+	if got, _ := s.Get("key"); got.Failures != 0 {
+		t.Errorf("Failures = %d after Reset", got.Failures)
+	}
+}
+
+func TestMemoryLoginAttemptStoreForgetsOldFailures(t *testing.T) {
+	s := NewMemoryLoginAttemptStore()
+	s.Update("key", func(a *LoginAttempts) {
+		a.Failures = 3
+		a.LastFailure = time.Now().Add(-loginAttemptsRetention - time.Minute)
+	})
+
+	if got, _ := s.Get("key"); got.Failures != 0 {
+		t.Errorf("Failures = %d, want the old counter forgotten", got.Failures)
+	}
+
+	// a counter still locked is kept whatever the age of its last failure
+	s.Update("locked", func(a *LoginAttempts) {
+		a.Failures = 9
+		a.LastFailure = time.Now().Add(-loginAttemptsRetention - time.Minute)
+		a.LockedUntil = time.Now().Add(time.Hour)
+	})
+	if got, _ := s.Get("locked"); got.Failures != 9 {
+		t.Errorf("Failures = %d, want the locked counter kept", got.Failures)
+	}
+}
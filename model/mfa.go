@@ -0,0 +1,190 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sec-app-server/db"
+	"sec-app-server/utils"
+	"strings"
+	"time"
+)
+
+const RecoveryCodeCount = 10
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotPending     = errors.New("no two-factor enrollment in progress")
+	ErrMFAInvalidCode    = errors.New("invalid two-factor code")
+)
+
+// StartMFAEnrollment generates a new TOTP secret for the user. It only
+// becomes active once confirmed with a first code. The secret is stored
+// encrypted, a dump of the database is not enough to compute codes.
+func StartMFAEnrollment(userID string) (string, error) {
+	secret := utils.GenerateTOTPSecret()
+	encrypted, err := utils.Encrypt(secret)
+	if err != nil {
+		fmt.Println("Error encrypting mfa secret:", err)
+		return "", err
+	}
+
+	res, err := db.DB.Exec(`
+		INSERT INTO user_mfa (user_id, secret, enabled, last_used_step, created_at)
+		VALUES ($1, $2, false, 0, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = EXCLUDED.created_at
+		WHERE user_mfa.enabled = false
+	`, userID, encrypted)
+	if err != nil {
+		fmt.Println("Error starting mfa enrollment:", err)
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", ErrMFAAlreadyEnabled
+	}
+	return secret, nil
+}
+
+// ConfirmMFAEnrollment enables two-factor authentication if the code matches
+// the pending secret, and returns the recovery codes. They are only ever
+// shown this once.
+func ConfirmMFAEnrollment(userID, code string) ([]string, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var secret string
+	var enabled bool
+	err = tx.QueryRow("SELECT secret, enabled FROM user_mfa WHERE user_id = $1 FOR UPDATE", userID).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		return nil, ErrMFANotPending
+	}
+	if err != nil {
+		fmt.Println("Error fetching mfa enrollment:", err)
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if secret, err = decryptMFASecret(secret); err != nil {
+		return nil, err
+	}
+
+	ok, step := utils.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, ErrMFAInvalidCode
+	}
+
+	if _, err := tx.Exec("UPDATE user_mfa SET enabled = true, last_used_step = $1 WHERE user_id = $2", step, userID); err != nil {
+		fmt.Println("Error enabling mfa:", err)
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		fmt.Println("Error clearing recovery codes:", err)
+		return nil, err
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		raw := utils.GenerateTokenWithEncoding(10, utils.Base32)
+		codes[i] = raw[:8] + "-" + raw[8:]
+		if _, err := tx.Exec("INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, utils.HashString(raw)); err != nil {
+			fmt.Println("Error storing recovery code:", err)
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func IsMFAEnabled(userID string) (bool, error) {
+	var enabled bool
+	err := db.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM user_mfa WHERE user_id = $1 AND enabled)", userID).Scan(&enabled)
+	if err != nil {
+		fmt.Println("Error checking mfa:", err)
+		return false, err
+	}
+	return enabled, nil
+}
+
+// VerifyMFACode accepts either a TOTP code, which cannot be replayed, or an
+// unused recovery code, which is then burnt.
+func VerifyMFACode(userID, code string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var secret string
+	var lastUsedStep int64
+	err = tx.QueryRow("SELECT secret, last_used_step FROM user_mfa WHERE user_id = $1 AND enabled FOR UPDATE", userID).Scan(&secret, &lastUsedStep)
+	if err == sql.ErrNoRows {
+		return ErrMFAInvalidCode
+	}
+	if err != nil {
+		fmt.Println("Error fetching mfa secret:", err)
+		return err
+	}
+	if secret, err = decryptMFASecret(secret); err != nil {
+		return err
+	}
+
+	if ok, step := utils.ValidateTOTP(secret, code, time.Now()); ok {
+		if step <= lastUsedStep {
+			return ErrMFAInvalidCode
+		}
+		if _, err := tx.Exec("UPDATE user_mfa SET last_used_step = $1 WHERE user_id = $2", step, userID); err != nil {
+			fmt.Println("Error updating mfa step:", err)
+			return err
+		}
+		return tx.Commit()
+	}
+
+	recovery := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	res, err := tx.Exec(`
+		UPDATE mfa_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, utils.HashString(recovery))
+	if err != nil {
+		fmt.Println("Error consuming recovery code:", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMFAInvalidCode
+	}
+	return tx.Commit()
+}
+
+func decryptMFASecret(encrypted string) (string, error) {
+	secret, err := utils.Decrypt(encrypted)
+	if err != nil {
+		fmt.Println("Error decrypting mfa secret:", err)
+	}
+	return secret, err
+}
+
+// DisableMFA removes the secret and the recovery codes together
+func DisableMFA(userID string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_mfa WHERE user_id = $1", userID); err != nil {
+		fmt.Println("Error disabling mfa:", err)
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		fmt.Println("Error clearing recovery codes:", err)
+		return err
+	}
+	return tx.Commit()
+}
@@ -1,30 +1,79 @@
 package model
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"sec-app-server/db"
+	"sec-app-server/money"
+	"sec-app-server/utils"
+	"strings"
+	"time"
 )
 
+var ErrCartEmpty = errors.New("cart is empty")
+
+// OutOfStockError lists every cart line the stock cannot cover at checkout
+type OutOfStockError struct {
+	Lines []InsufficientStockError `json:"lines"`
+}
+
+func (e *OutOfStockError) Error() string {
+	lines := make([]string, len(e.Lines))
+	for i, line := range e.Lines {
+		lines[i] = line.Error()
+	}
+	return "out of stock: " + strings.Join(lines, ", ")
+}
+
 type ContainedProduct struct {
 	OrderID   string `json:"order_id"`
 	Quantity  int    `json:"quantity"`
 	ProductID string `json:"product_id"`
+	// UnitPrice is the price paid, unknown for orders from before it was kept
+	UnitPrice *money.Money `json:"unit_price"`
+	// ProductName is the name at the time of the order
+	ProductName string `json:"product_name"`
+	// Product is the product as it is now, only loaded by GetOrderByID
+	Product *Product `json:"product,omitempty"`
 }
 
 type Order struct {
 	ID string `json:"id"`
 	Numero             string  `json:"numero"`
-	Price              float64 `json:"price"`
+	Price              money.Money `json:"price"`
 	Date               string  `json:"date"`
 	Status             string  `json:"status"`
 	DeliveryCoordinate string  `json:"delivery_coordinate"`
+	// DeliveryAddress is the copy of the address made at checkout
+	DeliveryAddress *Address `json:"delivery_address"`
+	CustomerID         string  `json:"customer_id,omitempty"`
 	Products           []ContainedProduct
+	// Discounts are taken off the lines, Price being what is left to pay
+	Discounts []OrderDiscount `json:"discounts"`
+}
+
+// OrderDiscount is a discount line of an order
+type OrderDiscount struct {
+	Code   string      `json:"code"`
+	Amount money.Money `json:"amount"`
+}
+
+// orderPrice is the price of an order as stored, its amounts being parsed in
+// the currency of the order rather than the current one of the shop
+type orderPrice struct {
+	amount   string
+	currency string
+}
+
+func (p orderPrice) money() (money.Money, error) {
+	return money.ParseIn(p.amount, p.currency)
 }
 
 func GetAllOrdersFromUser(userID string) ([]Order, error) {
 	orders := []Order{}
 
-	sql, err := db.DB.Query("SELECT id, numero, price, date, status, delivery_coordinate FROM orders o JOIN has_ordered a ON a.order_id=o.id WHERE user_id=$1", userID)
+	sql, err := db.DB.Query("SELECT id, numero, price, currency, date, status, COALESCE(delivery_coordinate, ''), delivery_address FROM orders o JOIN has_ordered a ON a.order_id=o.id WHERE user_id=$1", userID)
 	if err != nil {
 		fmt.Println("Error fetching orders:", err)
 		return nil, err
@@ -33,34 +82,199 @@ func GetAllOrdersFromUser(userID string) ([]Order, error) {
 
 	for sql.Next() {
 		var order Order
-		if err := sql.Scan(&order.ID, &order.Numero, &order.Price,  &order.Date,  &order.Status, &order.DeliveryCoordinate); err != nil {
+		var price orderPrice
+		if err := sql.Scan(&order.ID, &order.Numero, &price.amount, &price.currency,  &order.Date,  &order.Status, &order.DeliveryCoordinate, &addressColumn{&order.DeliveryAddress}); err != nil {
+			return nil, err
+		}
+		if order.Price, err = price.money(); err != nil {
+			return nil, err
+		}
+
+		orders = append(orders, order)
+	}
+	if err := sql.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadOrderLines(orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// OrderCart turns the cart of the user into an order delivered to address in
+// one transaction: the stock of every product is locked and decremented, the
+// lines are written at the current prices and the cart is emptied. Nothing is
+// changed when a line is out of stock, see OutOfStockError.
+func OrderCart(userID string, address *Address) (*Order, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// locked in product order so that concurrent checkouts cannot deadlock
+	rows, err := tx.Query(`
+		SELECT p.id, p.name, p.stock, p.price, c.quantity
+		FROM cart c JOIN product p ON p.id = c.product_id
+		WHERE c.user_id = $1
+		ORDER BY p.id
+		FOR UPDATE
+	`, userID)
+	if err != nil {
+		fmt.Println("Error fetching cart items:", err)
+		return nil, err
+	}
+
+	type line struct {
+		productID int
+		name      string
+		stock     int
+		price     money.Money
+		quantity  int
+	}
+	var lines []line
+	for rows.Next() {
+		var l line
+		if err := rows.Scan(&l.productID, &l.name, &l.stock, &l.price, &l.quantity); err != nil {
+			rows.Close()
+			fmt.Println("Error scanning cart item:", err)
 			return nil, err
 		}
+		lines = append(lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(lines) == 0 {
+		return nil, ErrCartEmpty
+	}
 
-		productRows, err := db.DB.Query(`
-			SELECT product_id, quantity 
-			FROM contains_product
-			WHERE order_id = $1
-		`, order.ID)
+	outOfStock := &OutOfStockError{}
+	for _, l := range lines {
+		if l.quantity > l.stock {
+			outOfStock.Lines = append(outOfStock.Lines, InsufficientStockError{ProductID: l.productID, Requested: l.quantity, Available: l.stock})
+		}
+	}
+	if len(outOfStock.Lines) > 0 {
+		return nil, outOfStock
+	}
+
+	snapshot := address.snapshot()
+	order := &Order{
+		Numero:             utils.GeneratePrefixedID("ord", 10),
+		Date:               utils.GetCurrentDate(),
+		Status:             OrderPending,
+		DeliveryCoordinate: snapshot.String(),
+		DeliveryAddress:    &snapshot,
+		Products:           []ContainedProduct{},
+	}
+	addressJSON, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	itemCount := 0
+	priced := make([]pricedLine, len(lines))
+	order.Price = money.New(0)
+	for i, l := range lines {
+		order.Price = order.Price.Add(l.price.Mul(l.quantity))
+		itemCount += l.quantity
+		priced[i] = pricedLine{productID: l.productID, price: l.price, quantity: l.quantity}
+	}
+
+	promo, err := cartPromo(tx, userID, true)
+	if err != nil {
+		return nil, err
+	}
+	order.Discounts = []OrderDiscount{}
+	if promo != nil {
+		discount, err := promoDiscount(tx, promo, userID, priced, time.Now())
 		if err != nil {
 			return nil, err
 		}
-		defer productRows.Close()
-
-		var containedProducts []ContainedProduct
-		for productRows.Next() {
-			var cp ContainedProduct
-			cp.OrderID = order.ID
-			if err := productRows.Scan(&cp.ProductID, &cp.Quantity); err != nil {
-				return nil, err
-			}
-			containedProducts = append(containedProducts, cp)
+		order.Discounts = append(order.Discounts, OrderDiscount{Code: promo.Code, Amount: discount})
+		order.Price = order.Price.Sub(discount)
+	}
+
+	err = tx.QueryRow(
+		"INSERT INTO orders (numero, price, currency, date, status, delivery_coordinate, delivery_address) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		order.Numero, order.Price, order.Price.Currency, order.Date, order.Status, order.DeliveryCoordinate, addressJSON,
+	).Scan(&order.ID)
+	if err != nil {
+		fmt.Println("Error executing order statement:", err)
+		return nil, err
+	}
+
+	for _, l := range lines {
+		if _, err := tx.Exec("UPDATE product SET stock = stock - $2 WHERE id = $1", l.productID, l.quantity); err != nil {
+			fmt.Println("Error decrementing stock:", err)
+			return nil, err
 		}
 
-		order.Products = containedProducts
+		_, err := tx.Exec(
+			"INSERT INTO contains_product (order_id, product_id, quantity, unit_price, product_name) VALUES ($1, $2, $3, $4, $5)",
+			order.ID, l.productID, l.quantity, l.price, l.name,
+		)
+		if err != nil {
+			fmt.Println("Error executing contains_product statement:", err)
+			return nil, err
+		}
 
-		orders = append(orders, order)
+		price := l.price
+		order.Products = append(order.Products, ContainedProduct{
+			OrderID:     order.ID,
+			ProductID:   fmt.Sprint(l.productID),
+			Quantity:    l.quantity,
+			UnitPrice:   &price,
+			ProductName: l.name,
+		})
 	}
 
-	return orders, nil
-}
\ No newline at end of file
+	if promo != nil {
+		discount := order.Discounts[0].Amount
+		_, err := tx.Exec(
+			"INSERT INTO order_discounts (order_id, promo_code_id, code, amount) VALUES ($1, $2, $3, $4)",
+			order.ID, promo.ID, promo.Code, discount,
+		)
+		if err != nil {
+			fmt.Println("Error executing order_discounts statement:", err)
+			return nil, err
+		}
+
+		_, err = tx.Exec(
+			"INSERT INTO promo_redemptions (promo_code_id, user_id, order_id) VALUES ($1, $2, $3)",
+			promo.ID, userID, order.ID,
+		)
+		if err != nil {
+			fmt.Println("Error recording promo redemption:", err)
+			return nil, err
+		}
+	}
+
+	err = recordOrderStatus(tx, &OrderStatusChange{OrderID: order.ID, To: OrderPending, ChangedBy: userID, ChangedAt: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("INSERT INTO has_ordered (user_id, order_id, quantity) VALUES ($1, $2, $3)", userID, order.ID, itemCount)
+	if err != nil {
+		fmt.Println("Error executing has_ordered statement:", err)
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM cart WHERE user_id = $1", userID); err != nil {
+		fmt.Println("Error executing clear cart statement:", err)
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM cart_promo WHERE user_id = $1", userID); err != nil {
+		fmt.Println("Error removing promo code:", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
@@ -0,0 +1,425 @@
+package model
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sec-app-server/db"
+	"sec-app-server/money"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	DefaultOrderPageSize = 20
+	MaxOrderPageSize     = 100
+)
+
+var (
+	ErrInvalidCursor    = errors.New("invalid cursor")
+	ErrInvalidOrderSort = errors.New("invalid sort")
+)
+
+// orderSort is a column the orders can be ordered by, and the type of its
+// values in the cursors
+type orderSort struct {
+	column string
+	value  cursorValue
+}
+
+// orderSorts maps the accepted sort parameters to the column they order by,
+// a leading "-" meaning descending
+var orderSorts = map[string]orderSort{
+	"date":  {"o.date", cursorTimestamp},
+	"total": {"o.price", cursorNumber},
+}
+
+// OrderFilter selects the orders listed by ListOrders. Zero values do not
+// filter.
+type OrderFilter struct {
+	Status   string
+	From     time.Time
+	To       time.Time
+	UserID   string
+	MinTotal *money.Money
+	// Sort is "date", "total", "-date" or "-total", by default "-date"
+	Sort   string
+	Cursor string
+	Limit  int
+}
+
+type OrderPage struct {
+	Orders     []Order `json:"orders"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// pageCursor points after the last row of a keyset paginated page. It
+// carries the sort, and the digest of the search text if any, so that it
+// cannot be replayed with another one.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Query string `json:"q,omitempty"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// cursorValue is the type of the sort column, the value of a cursor must
+// parse as one before it is compared to the column
+type cursorValue int
+
+const (
+	cursorInteger cursorValue = iota
+	cursorNumber
+	cursorTimestamp
+)
+
+var (
+	integerPattern = regexp.MustCompile(`^-?[0-9]+$`)
+	// NUMERIC, REAL and DOUBLE PRECISION as PostgreSQL prints them
+	numberPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?(e[-+][0-9]+)?$`)
+	// TIMESTAMP and TIMESTAMPTZ as PostgreSQL prints them with the ISO DateStyle
+	timestampLayouts = []string{"2006-01-02 15:04:05.999999999", "2006-01-02 15:04:05.999999999Z07", "2006-01-02 15:04:05.999999999Z07:00"}
+)
+
+func (v cursorValue) valid(value string) bool {
+	switch v {
+	case cursorInteger:
+		return integerPattern.MatchString(value)
+	case cursorNumber:
+		return numberPattern.MatchString(value)
+	case cursorTimestamp:
+		for _, layout := range timestampLayouts {
+			if _, err := time.Parse(layout, value); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// queryDigest identifies a search text in its cursors without copying it
+func queryDigest(text string) string {
+	if text == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(text))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// ListOrders returns a page of every customer's orders, with their lines
+func ListOrders(filter OrderFilter) (*OrderPage, error) {
+	sort := filter.Sort
+	if sort == "" {
+		sort = "-date"
+	}
+	descending := strings.HasPrefix(sort, "-")
+	orderSort, ok := orderSorts[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		return nil, ErrInvalidOrderSort
+	}
+	sortColumn := orderSort.column
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultOrderPageSize
+	}
+	limit = min(limit, MaxOrderPageSize)
+
+	var conditions []string
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Status != "" {
+		conditions = append(conditions, "o.status = "+arg(filter.Status))
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "o.date >= "+arg(filter.From.Format(time.DateTime)))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "o.date < "+arg(filter.To.Format(time.DateTime)))
+	}
+	if filter.UserID != "" {
+		conditions = append(conditions, "h.user_id = "+arg(filter.UserID))
+	}
+	if filter.MinTotal != nil {
+		conditions = append(conditions, "o.price >= "+arg(filter.MinTotal.String()))
+	}
+	if filter.Cursor != "" {
+		cursor, err := decodeCursor(filter.Cursor, sort, "", orderSort.value)
+		if err != nil {
+			return nil, err
+		}
+		operator := ">"
+		if descending {
+			operator = "<"
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, o.id) %s (%s, %s)", sortColumn, operator, arg(cursor.Value), arg(cursor.ID)))
+	}
+
+	direction := "ASC"
+	if descending {
+		direction = "DESC"
+	}
+
+	query := `
+		SELECT o.id, o.numero, o.price, o.currency, o.date, o.status, COALESCE(o.delivery_coordinate, ''), o.delivery_address, COALESCE(h.user_id::TEXT, ''), (` + sortColumn + `)::TEXT
+		FROM orders o
+		LEFT JOIN LATERAL (SELECT user_id FROM has_ordered WHERE order_id = o.id LIMIT 1) h ON true`
+	if len(conditions) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf("\n\t\tORDER BY %s %s, o.id %s\n\t\tLIMIT %s", sortColumn, direction, direction, arg(limit+1))
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		fmt.Println("Error listing orders:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &OrderPage{Orders: []Order{}}
+	var sortValues []string
+	for rows.Next() {
+		var order Order
+		var price orderPrice
+		var sortValue string
+		if err := rows.Scan(&order.ID, &order.Numero, &price.amount, &price.currency, &order.Date, &order.Status, &order.DeliveryCoordinate, &addressColumn{&order.DeliveryAddress}, &order.CustomerID, &sortValue); err != nil {
+			return nil, err
+		}
+		if order.Price, err = price.money(); err != nil {
+			return nil, err
+		}
+		page.Orders = append(page.Orders, order)
+		sortValues = append(sortValues, sortValue)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Orders) > limit {
+		page.Orders = page.Orders[:limit]
+		id, _ := strconv.Atoi(page.Orders[limit-1].ID)
+		page.NextCursor = encodeCursor(pageCursor{Sort: sort, Value: sortValues[limit-1], ID: id})
+	}
+
+	if err := loadOrderLines(page.Orders); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func encodeCursor(cursor pageCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor returns ErrInvalidCursor when the cursor is malformed, was
+// made for another sort or search text, or its value is not of the type of
+// the sort column
+func decodeCursor(encoded, sort, query string, value cursorValue) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Sort != sort || cursor.Query != queryDigest(query) || !value.valid(cursor.Value) {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// loadOrderLines fills the Products and Discounts of the orders, one query
+// each
+func loadOrderLines(orders []Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	byID := map[string]*Order{}
+	ids := make([]int, 0, len(orders))
+	for i := range orders {
+		orders[i].Products = []ContainedProduct{}
+		orders[i].Discounts = []OrderDiscount{}
+		byID[orders[i].ID] = &orders[i]
+		if id, err := strconv.Atoi(orders[i].ID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT cp.order_id, cp.product_id, cp.quantity, cp.unit_price, COALESCE(cp.product_name, p.name, '')
+		FROM contains_product cp LEFT JOIN product p ON p.id = cp.product_id
+		WHERE cp.order_id = ANY($1::INTEGER[])
+		ORDER BY cp.order_id, cp.product_id
+	`, pq.Array(ids))
+	if err != nil {
+		fmt.Println("Error fetching order lines:", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cp ContainedProduct
+		var unitPrice sql.NullString
+		if err := rows.Scan(&cp.OrderID, &cp.ProductID, &cp.Quantity, &unitPrice, &cp.ProductName); err != nil {
+			return err
+		}
+		order, ok := byID[cp.OrderID]
+		if !ok {
+			continue
+		}
+		if unitPrice.Valid {
+			price, err := money.ParseIn(unitPrice.String, order.Price.Currency)
+			if err != nil {
+				return err
+			}
+			cp.UnitPrice = &price
+		}
+		order.Products = append(order.Products, cp)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	discountRows, err := db.DB.Query(`
+		SELECT order_id, code, amount
+		FROM order_discounts
+		WHERE order_id = ANY($1::INTEGER[])
+		ORDER BY id
+	`, pq.Array(ids))
+	if err != nil {
+		fmt.Println("Error fetching order discounts:", err)
+		return err
+	}
+	defer discountRows.Close()
+
+	for discountRows.Next() {
+		var orderID, amount string
+		var discount OrderDiscount
+		if err := discountRows.Scan(&orderID, &discount.Code, &amount); err != nil {
+			return err
+		}
+		order, ok := byID[orderID]
+		if !ok {
+			continue
+		}
+		if discount.Amount, err = money.ParseIn(amount, order.Price.Currency); err != nil {
+			return err
+		}
+		order.Discounts = append(order.Discounts, discount)
+	}
+	return discountRows.Err()
+}
+
+type OrderCustomer struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// OrderDetail is an order with everything an admin needs to handle it
+type OrderDetail struct {
+	Order
+	Customer *OrderCustomer      `json:"customer"`
+	Payment  *OrderPayment       `json:"payment"`
+	History  []OrderStatusChange `json:"history"`
+}
+
+// GetOrderByID returns the order with its lines, each with the product as it
+// is now next to what was bought, its customer and its status history
+func GetOrderByID(id string) (*OrderDetail, error) {
+	detail := &OrderDetail{}
+	var price orderPrice
+	err := db.DB.QueryRow(`
+		SELECT o.id, o.numero, o.price, o.currency, o.date, o.status, COALESCE(o.delivery_coordinate, ''), o.delivery_address, COALESCE(h.user_id::TEXT, '')
+		FROM orders o
+		LEFT JOIN LATERAL (SELECT user_id FROM has_ordered WHERE order_id = o.id LIMIT 1) h ON true
+		WHERE o.id = $1
+	`, id).Scan(&detail.ID, &detail.Numero, &price.amount, &price.currency, &detail.Date, &detail.Status, &detail.DeliveryCoordinate, &addressColumn{&detail.DeliveryAddress}, &detail.CustomerID)
+	if err == sql.ErrNoRows {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		fmt.Println("Error fetching order:", err)
+		return nil, err
+	}
+	if detail.Price, err = price.money(); err != nil {
+		return nil, err
+	}
+
+	orders := []Order{detail.Order}
+	if err := loadOrderLines(orders); err != nil {
+		return nil, err
+	}
+	detail.Order = orders[0]
+
+	ids := make([]int, 0, len(detail.Products))
+	for _, line := range detail.Products {
+		if id, err := strconv.Atoi(line.ProductID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	products, err := loadProducts(ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]*Product{}
+	for i := range products {
+		byID[strconv.Itoa(products[i].ID)] = &products[i]
+	}
+	for i := range detail.Products {
+		// the product may have been deleted since, the line still tells what it was
+		detail.Products[i].Product = byID[detail.Products[i].ProductID]
+	}
+
+	if detail.CustomerID != "" {
+		if user, err := GetUserByID(detail.CustomerID); err == nil {
+			detail.Customer = &OrderCustomer{ID: user.ID, Username: user.Username}
+		}
+	}
+
+	detail.Payment, err = GetOrderPayment(detail.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	detail.History, err = GetOrderStatusHistory(detail.ID)
+	if err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+// GetOrderStatusHistory returns the status changes of the order, oldest first
+func GetOrderStatusHistory(orderID string) ([]OrderStatusChange, error) {
+	history := []OrderStatusChange{}
+	rows, err := db.DB.Query(`
+		SELECT h.order_id, o.numero, COALESCE(h.from_status, ''), h.to_status, COALESCE(h.changed_by::TEXT, ''), h.note, h.changed_at
+		FROM order_status_history h JOIN orders o ON o.id = h.order_id
+		WHERE h.order_id = $1
+		ORDER BY h.changed_at, h.id
+	`, orderID)
+	if err != nil {
+		fmt.Println("Error fetching order status history:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var change OrderStatusChange
+		if err := rows.Scan(&change.OrderID, &change.Numero, &change.From, &change.To, &change.ChangedBy, &change.Note, &change.ChangedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
@@ -0,0 +1,59 @@
+package model
+
+import "testing"
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		cursor pageCursor
+		query  string
+		value  cursorValue
+	}{
+		{pageCursor{Sort: "-total", Value: "42.50", ID: 17}, "", cursorNumber},
+		{pageCursor{Sort: "date", Value: "2024-03-01 10:20:30", ID: 17}, "", cursorTimestamp},
+		{pageCursor{Sort: "date", Value: "2024-03-01 10:20:30.123456+01", ID: 17}, "", cursorTimestamp},
+		{pageCursor{Sort: "newest", Value: "17", ID: 17}, "", cursorInteger},
+		{pageCursor{Sort: "relevance", Query: queryDigest("huile cbd"), Value: "1.2e-05", ID: 17}, "huile cbd", cursorNumber},
+	}
+	for _, tt := range tests {
+		got, err := decodeCursor(encodeCursor(tt.cursor), tt.cursor.Sort, tt.query, tt.value)
+		if err != nil {
+			t.Errorf("decodeCursor(%+v) = %v", tt.cursor, err)
+			continue
+		}
+		if *got != tt.cursor {
+			t.Errorf("decodeCursor = %+v, want %+v", *got, tt.cursor)
+		}
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	search := queryDigest("huile cbd")
+
+	tests := []struct {
+		name   string
+		cursor string
+		sort   string
+		query  string
+		value  cursorValue
+	}{
+		{"another sort", encodeCursor(pageCursor{Sort: "total", Value: "42.50", ID: 17}), "-total", "", cursorNumber},
+		{"not base64", "not a cursor!", "-total", "", cursorNumber},
+		{"not json", "bm90IGpzb24", "-total", "", cursorNumber},
+		{"empty", "", "-total", "", cursorNumber},
+		{"text as a number", encodeCursor(pageCursor{Sort: "-total", Value: "abc", ID: 17}), "-total", "", cursorNumber},
+		{"empty number", encodeCursor(pageCursor{Sort: "-total", Value: "", ID: 17}), "-total", "", cursorNumber},
+		{"NaN", encodeCursor(pageCursor{Sort: "-total", Value: "NaN", ID: 17}), "-total", "", cursorNumber},
+		{"decimal as an integer", encodeCursor(pageCursor{Sort: "newest", Value: "1.5", ID: 17}), "newest", "", cursorInteger},
+		{"text as a timestamp", encodeCursor(pageCursor{Sort: "date", Value: "yesterday", ID: 17}), "date", "", cursorTimestamp},
+		{"another search", encodeCursor(pageCursor{Sort: "relevance", Query: search, Value: "0.5", ID: 17}), "relevance", "huile", cursorNumber},
+		{"catalog cursor in a search", encodeCursor(pageCursor{Sort: "price", Value: "9.90", ID: 17}), "price", "huile cbd", cursorNumber},
+		{"search cursor in the catalog", encodeCursor(pageCursor{Sort: "price", Query: search, Value: "9.90", ID: 17}), "price", "", cursorNumber},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCursor(tt.cursor, tt.sort, tt.query, tt.value); err != ErrInvalidCursor {
+				t.Errorf("decodeCursor = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
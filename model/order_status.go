@@ -0,0 +1,150 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sec-app-server/db"
+	"slices"
+	"time"
+)
+
+const (
+	OrderPending   = "pending"
+	OrderPaid      = "paid"
+	OrderFailed    = "failed"
+	OrderPreparing = "preparing"
+	OrderShipped   = "shipped"
+	OrderDelivered = "delivered"
+	OrderCancelled = "cancelled"
+	OrderRefunded  = "refunded"
+)
+
+// orderTransitions lists the statuses an order can move to from each status.
+// A paid order is not cancelled but refunded, a failed payment can be retried.
+var orderTransitions = map[string][]string{
+	OrderPending:   {OrderPaid, OrderFailed, OrderCancelled},
+	OrderFailed:    {OrderPaid, OrderCancelled},
+	OrderPaid:      {OrderPreparing, OrderRefunded},
+	OrderPreparing: {OrderShipped, OrderRefunded},
+	OrderShipped:   {OrderDelivered},
+	OrderDelivered: {OrderRefunded},
+	OrderCancelled: {},
+	OrderRefunded:  {},
+}
+
+var (
+	ErrOrderNotFound      = errors.New("order not found")
+	ErrUnknownOrderStatus = errors.New("unknown order status")
+)
+
+type InvalidTransitionError struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("order cannot go from %s to %s", e.From, e.To)
+}
+
+func IsOrderStatus(status string) bool {
+	_, ok := orderTransitions[status]
+	return ok
+}
+
+func CanTransitionOrder(from, to string) bool {
+	return slices.Contains(orderTransitions[from], to)
+}
+
+type OrderStatusChange struct {
+	OrderID    string    `json:"order_id"`
+	Numero     string    `json:"numero"`
+	CustomerID string    `json:"customer_id,omitempty"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	ChangedBy  string    `json:"changed_by"`
+	Note       string    `json:"note"`
+	ChangedAt  time.Time `json:"changed_at"`
+}
+
+// ChangeOrderStatus moves the order to status if the transition is allowed,
+// and records it in the history. The stock reserved at checkout is given back
+// when the goods never left: cancelled orders and refunds before shipping.
+func ChangeOrderStatus(orderID, status, changedBy, note string) (*OrderStatusChange, error) {
+	if !IsOrderStatus(status) {
+		return nil, ErrUnknownOrderStatus
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	change, err := changeOrderStatus(tx, orderID, status, changedBy, note)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+func changeOrderStatus(tx *sql.Tx, orderID, status, changedBy, note string) (*OrderStatusChange, error) {
+	change := &OrderStatusChange{OrderID: orderID, To: status, ChangedBy: changedBy, Note: note, ChangedAt: time.Now()}
+	var customerID sql.NullString
+	err := tx.QueryRow(`
+		SELECT o.numero, o.status, (SELECT h.user_id FROM has_ordered h WHERE h.order_id = o.id LIMIT 1)
+		FROM orders o
+		WHERE o.id = $1
+		FOR UPDATE OF o
+	`, orderID).Scan(&change.Numero, &change.From, &customerID)
+	if err == sql.ErrNoRows {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		fmt.Println("Error fetching order:", err)
+		return nil, err
+	}
+	change.CustomerID = customerID.String
+
+	if !CanTransitionOrder(change.From, status) {
+		return nil, &InvalidTransitionError{From: change.From, To: status}
+	}
+
+	if _, err := tx.Exec("UPDATE orders SET status = $2 WHERE id = $1", orderID, status); err != nil {
+		fmt.Println("Error updating order status:", err)
+		return nil, err
+	}
+
+	if err := recordOrderStatus(tx, change); err != nil {
+		return nil, err
+	}
+
+	if status == OrderCancelled || (status == OrderRefunded && (change.From == OrderPaid || change.From == OrderPreparing)) {
+		_, err := tx.Exec(`
+			UPDATE product p SET stock = p.stock + cp.quantity
+			FROM contains_product cp
+			WHERE cp.order_id = $1 AND cp.product_id = p.id
+		`, orderID)
+		if err != nil {
+			fmt.Println("Error restocking order:", err)
+			return nil, err
+		}
+	}
+
+	return change, nil
+}
+
+func recordOrderStatus(tx *sql.Tx, change *OrderStatusChange) error {
+	_, err := tx.Exec(`
+		INSERT INTO order_status_history (order_id, from_status, to_status, changed_by, note, changed_at)
+		VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, '')::INTEGER, $5, $6)
+	`, change.OrderID, change.From, change.To, change.ChangedBy, change.Note, change.ChangedAt)
+	if err != nil {
+		fmt.Println("Error recording order status:", err)
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sec-app-server/db"
+	"sec-app-server/utils"
+	"time"
+)
+
+const PasswordResetTokenLifetime = time.Hour
+
+var ErrPasswordResetTokenInvalid = errors.New("password reset token is invalid or expired")
+
+// CreatePasswordResetToken returns a new single use reset token for the user,
+// replacing any that was still pending. Only its hash is stored.
+func CreatePasswordResetToken(userID string) (string, error) {
+	token := utils.GenerateToken(utils.DefaultTokenBytes)
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM password_reset_tokens WHERE user_id = $1 AND used_at IS NULL", userID); err != nil {
+		fmt.Println("Error clearing password reset tokens:", err)
+		return "", err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO password_reset_tokens (token_hash, user_id, expires_at) VALUES ($1, $2, $3)
+	`, utils.HashString(token), userID, time.Now().Add(PasswordResetTokenLifetime))
+	if err != nil {
+		fmt.Println("Error creating password reset token:", err)
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ResetPassword consumes the reset token and sets the new password of its
+// user, which is returned.
+func ResetPassword(token, newPassword string) (*User, error) {
+	passwordHash, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var user User
+	err = tx.QueryRow(`
+		SELECT u.id, u.username, u.email
+		FROM password_reset_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 AND t.used_at IS NULL AND t.expires_at > NOW()
+		FOR UPDATE OF t
+	`, utils.HashString(token)).Scan(&user.ID, &user.Username, &user.Email)
+	if err == sql.ErrNoRows {
+		return nil, ErrPasswordResetTokenInvalid
+	}
+	if err != nil {
+		fmt.Println("Error fetching password reset token:", err)
+		return nil, err
+	}
+
+	if _, err := tx.Exec("UPDATE password_reset_tokens SET used_at = NOW() WHERE token_hash = $1", utils.HashString(token)); err != nil {
+		fmt.Println("Error consuming password reset token:", err)
+		return nil, err
+	}
+
+	if _, err := tx.Exec("UPDATE users SET password = $1 WHERE id = $2", passwordHash, user.ID); err != nil {
+		fmt.Println("Error resetting password:", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
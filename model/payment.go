@@ -0,0 +1,260 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sec-app-server/db"
+	"sec-app-server/money"
+)
+
+var ErrRefundInProgress = errors.New("a refund of the order is in progress")
+
+// refundClaimTimeout is after how long a refund claim left by a crash can be
+// taken over, the idempotency key keeping the provider from refunding twice
+const refundClaimTimeout = "5 minutes"
+
+// OrderPayment holds the ids the payment provider gave to the payment of an
+// order, and the currency the payment was asked in
+type OrderPayment struct {
+	Provider string `json:"provider"`
+	IntentID string `json:"intent_id"`
+	Currency string `json:"currency"`
+	RefundID string `json:"refund_id,omitempty"`
+}
+
+// SetOrderPaymentIntent records an intent started for the order, which
+// becomes the one it is being paid with. The intents it replaces keep leading
+// to the order, their webhooks may still come.
+func SetOrderPaymentIntent(orderID, provider, intentID string, amount money.Money) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// a retried call of the provider gives back the intent already recorded
+	_, err = tx.Exec(`
+		INSERT INTO payment_intents (provider, intent_id, order_id, amount, currency)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING
+	`, provider, intentID, orderID, amount, amount.Currency)
+	if err != nil {
+		fmt.Println("Error saving payment intent:", err)
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE orders SET payment_provider = $2, payment_intent_id = $3, payment_currency = $4 WHERE id = $1", orderID, provider, intentID, amount.Currency)
+	if err != nil {
+		fmt.Println("Error saving payment intent:", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// CountOrderPaymentIntents returns the number of intents started for the
+// order, numbering its payment attempts
+func CountOrderPaymentIntents(orderID string) (int, error) {
+	var count int
+	err := db.DB.QueryRow("SELECT COUNT(*) FROM payment_intents WHERE order_id = $1", orderID).Scan(&count)
+	if err != nil {
+		fmt.Println("Error counting payment intents:", err)
+	}
+	return count, err
+}
+
+// ClaimOrderRefund marks the refund of the order as started, so that a
+// single request calls the provider. It returns false when the order was
+// already refunded and ErrRefundInProgress when another request holds the
+// claim.
+func ClaimOrderRefund(orderID string) (bool, error) {
+	res, err := db.DB.Exec(`
+		UPDATE orders SET payment_refund_claimed_at = NOW()
+		WHERE id = $1 AND payment_refund_id IS NULL
+			AND (payment_refund_claimed_at IS NULL OR payment_refund_claimed_at < NOW() - INTERVAL '`+refundClaimTimeout+`')
+	`, orderID)
+	if err != nil {
+		fmt.Println("Error claiming refund:", err)
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return true, nil
+	}
+
+	var refundID sql.NullString
+	err = db.DB.QueryRow("SELECT payment_refund_id FROM orders WHERE id = $1", orderID).Scan(&refundID)
+	if err == sql.ErrNoRows {
+		return false, ErrOrderNotFound
+	}
+	if err != nil {
+		fmt.Println("Error fetching refund:", err)
+		return false, err
+	}
+	if refundID.Valid {
+		return false, nil
+	}
+	return false, ErrRefundInProgress
+}
+
+// ReleaseOrderRefund gives up the claim of a refund that failed, so that it
+// can be retried at once
+func ReleaseOrderRefund(orderID string) error {
+	_, err := db.DB.Exec("UPDATE orders SET payment_refund_claimed_at = NULL WHERE id = $1 AND payment_refund_id IS NULL", orderID)
+	if err != nil {
+		fmt.Println("Error releasing refund:", err)
+	}
+	return err
+}
+
+func SetOrderRefund(orderID, refundID string) error {
+	_, err := db.DB.Exec("UPDATE orders SET payment_refund_id = $2 WHERE id = $1", orderID, refundID)
+	if err != nil {
+		fmt.Println("Error saving refund:", err)
+	}
+	return err
+}
+
+// GetOrderPayment returns nil when no payment was started for the order
+func GetOrderPayment(orderID string) (*OrderPayment, error) {
+	var provider, intentID, currency, refundID sql.NullString
+	err := db.DB.QueryRow(
+		"SELECT payment_provider, payment_intent_id, COALESCE(payment_currency, currency), payment_refund_id FROM orders WHERE id = $1", orderID,
+	).Scan(&provider, &intentID, &currency, &refundID)
+	if err == sql.ErrNoRows {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		fmt.Println("Error fetching order payment:", err)
+		return nil, err
+	}
+	if !intentID.Valid {
+		return nil, nil
+	}
+	return &OrderPayment{Provider: provider.String, IntentID: intentID.String, Currency: currency.String, RefundID: refundID.String}, nil
+}
+
+// ApplyPaymentEvent moves the order of the intent to status. Every event is
+// applied once: a delivery already seen, or an event the order is past (a
+// failure reported after the payment went through), changes nothing and
+// returns a nil change.
+//
+// An order is paid by whichever of its intents succeeds first. An intent
+// that succeeds after that, or once the order was cancelled, is marked as
+// due a refund, see GetStrayPayment.
+func ApplyPaymentEvent(provider, eventID, intentID, status string) (*OrderStatusChange, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// the order is locked so that two intents succeeding together are seen
+	// one after the other
+	var orderID, current, currentIntentID string
+	var paid bool
+	err = tx.QueryRow(`
+		SELECT o.id, o.status, COALESCE(o.payment_intent_id, ''), i.paid
+		FROM payment_intents i JOIN orders o ON o.id = i.order_id
+		WHERE i.provider = $1 AND i.intent_id = $2
+		FOR UPDATE OF o
+	`, provider, intentID).Scan(&orderID, &current, &currentIntentID, &paid)
+	if err == sql.ErrNoRows {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		fmt.Println("Error fetching order of payment:", err)
+		return nil, err
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO payment_events (provider, event_id, order_id, status)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+	`, provider, eventID, orderID, status)
+	if err != nil {
+		fmt.Println("Error recording payment event:", err)
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, nil
+	}
+
+	var change *OrderStatusChange
+	switch {
+	case status == OrderPaid && CanTransitionOrder(current, status):
+		// refunds go through the intent the order was paid with, whichever
+		// attempt it was
+		_, err = tx.Exec(`
+			UPDATE orders SET payment_intent_id = i.intent_id, payment_currency = i.currency
+			FROM payment_intents i
+			WHERE orders.id = $1 AND i.provider = $2 AND i.intent_id = $3
+		`, orderID, provider, intentID)
+		if err == nil {
+			_, err = tx.Exec("UPDATE payment_intents SET paid = true WHERE provider = $1 AND intent_id = $2", provider, intentID)
+		}
+		if err != nil {
+			fmt.Println("Error recording paid intent:", err)
+			return nil, err
+		}
+		change, err = changeOrderStatus(tx, orderID, status, "", "")
+		if err != nil {
+			return nil, err
+		}
+	case status == OrderPaid && !paid:
+		fmt.Println("Payment captured for order", orderID, "which is", current, "already, refunding intent", intentID)
+		_, err = tx.Exec("UPDATE payment_intents SET refund_due = true WHERE provider = $1 AND intent_id = $2", provider, intentID)
+		if err != nil {
+			fmt.Println("Error recording stray payment:", err)
+			return nil, err
+		}
+	case status != OrderPaid && intentID == currentIntentID && CanTransitionOrder(current, status):
+		// the failure of an attempt a retry replaced does not fail the order
+		change, err = changeOrderStatus(tx, orderID, status, "", "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// StrayPayment is money captured through an intent of an order that could
+// not take it, to be given back
+type StrayPayment struct {
+	OrderID  string
+	IntentID string
+	Amount   money.Money
+}
+
+// GetStrayPayment returns the intent when it is due a refund that was not
+// made yet, nil otherwise
+func GetStrayPayment(provider, intentID string) (*StrayPayment, error) {
+	stray := &StrayPayment{IntentID: intentID}
+	var amount orderPrice
+	err := db.DB.QueryRow(`
+		SELECT order_id, amount, currency FROM payment_intents
+		WHERE provider = $1 AND intent_id = $2 AND refund_due AND refund_id IS NULL
+	`, provider, intentID).Scan(&stray.OrderID, &amount.amount, &amount.currency)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		fmt.Println("Error fetching stray payment:", err)
+		return nil, err
+	}
+	if stray.Amount, err = amount.money(); err != nil {
+		return nil, err
+	}
+	return stray, nil
+}
+
+func SetStrayPaymentRefund(provider, intentID, refundID string) error {
+	_, err := db.DB.Exec("UPDATE payment_intents SET refund_id = $3 WHERE provider = $1 AND intent_id = $2", provider, intentID, refundID)
+	if err != nil {
+		fmt.Println("Error saving stray payment refund:", err)
+	}
+	return err
+}
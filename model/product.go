@@ -1,30 +1,34 @@
 package model
 
 import (
-	"encoding/json"
+	"database/sql"
 	"fmt"
 	"sec-app-server/db"
+	"sec-app-server/money"
+	"strings"
+
+	"github.com/lib/pq"
 )
 
 type Product struct {
-	ID          int        `json:"id"`
-	Name        string     `json:"name"`
-	Genetics    string     `json:"genetics"`
-	Star        bool       `json:"star"`
-	Type        string     `json:"type"`
-	Stock       int        `json:"stock"`
-	Thc_rate    float64    `json:"thc_rate"`
-	Cbd_rate    float64    `json:"cbd_rate"`
-	Price       float64    `json:"price"`
-	Image       string     `json:"image"`
-	Description string     `json:"description"`
-	Rating      int        `json:"rating"`
-	Color       string     `json:"color"`
-	Flavors     []Flavor   `json:"flavors"`
-	Aspects     []Aspect   `json:"aspects"`
-	Effects     []Effet    `json:"effects"`
-	IdealFors   []IdealFor `json:"idealfors"`
-	Categories  []Category `json:"categories"`
+	ID          int         `json:"id"`
+	Name        string      `json:"name"`
+	Genetics    string      `json:"genetics"`
+	Star        bool        `json:"star"`
+	Type        string      `json:"type"`
+	Stock       int         `json:"stock"`
+	Thc_rate    float64     `json:"thc_rate"`
+	Cbd_rate    float64     `json:"cbd_rate"`
+	Price       money.Money `json:"price"`
+	Image       string      `json:"image"`
+	Description string      `json:"description"`
+	Rating      int         `json:"rating"`
+	Color       string      `json:"color"`
+	Flavors     []Flavor    `json:"flavors"`
+	Aspects     []Aspect    `json:"aspects"`
+	Effects     []Effet     `json:"effects"`
+	IdealFors   []IdealFor  `json:"idealfors"`
+	Categories  []Category  `json:"categories"`
 }
 
 type Flavor struct {
@@ -52,119 +56,141 @@ type Category struct {
 	Name string `json:"name"`
 }
 
+const productColumns = "id, name, genetics, star, type, stock, thc_rate, cbd_rate, price, image, description, rating, color"
+
+func scanProduct(row interface{ Scan(...any) error }) (*Product, error) {
+	var product Product
+	err := row.Scan(&product.ID, &product.Name, &product.Genetics, &product.Star, &product.Type, &product.Stock, &product.Thc_rate, &product.Cbd_rate, &product.Price, &product.Image, &product.Description, &product.Rating, &product.Color)
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
 func GetProducts() ([]Product, error) {
-	var products []Product
-	sql, err := db.DB.Query("SELECT * FROM product")
+	products := []Product{}
+	rows, err := db.DB.Query("SELECT " + productColumns + " FROM product ORDER BY id")
 	if err != nil {
 		fmt.Println("Error fetching products:", err)
 		return nil, err
 	}
-	defer sql.Close()
-
-	for sql.Next() {
-		var product Product
-		if err := sql.Scan(&product.ID, &product.Name, &product.Genetics, &product.Star, &product.Type, &product.Stock, &product.Thc_rate, &product.Cbd_rate, &product.Price, &product.Image, &product.Description, &product.Rating, &product.Color); err != nil {
-			fmt.Println(err)
-			return nil, err
-		}
-
-		product.Categories = []Category{}
-		sqlCategories, err := db.DB.Query(`
-			SELECT id, name
-			FROM category a JOIN belongs_to h ON a.id = h.category_id
-			WHERE product_id = $1
-		`, product.ID)
-		defer sqlCategories.Close()
+	defer rows.Close()
 
+	for rows.Next() {
+		product, err := scanProduct(rows)
 		if err != nil {
-			fmt.Println("sql::", err)
-		}
-
-		for sqlCategories.Next() {
-			var category Category
-			sqlCategories.Scan(&category.ID, &category.Name)
-			product.Categories = append(product.Categories, category)
+			fmt.Println("Error scanning product:", err)
+			return nil, err
 		}
+		products = append(products, *product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-		product.Aspects = []Aspect{}
-		sqlAspect, err := db.DB.Query(`
-			SELECT id, name
-			FROM aspect a JOIN has_aspect h ON a.id = h.aspect_id
-			WHERE product_id = $1
-		`, product.ID)
-		defer sqlAspect.Close()
-
-		if err != nil {
-			fmt.Println("sql::", err)
-		}
+	if err := loadProductTerms(products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
 
-		for sqlAspect.Next() {
-			var aspect Aspect
-			sqlAspect.Scan(&aspect.ID, &aspect.Name)
-			product.Aspects = append(product.Aspects, aspect)
-		}
+// loadProducts returns the products with the given ids in the same order,
+// with their taxonomy terms. Ids of missing products are skipped.
+func loadProducts(ids []int) ([]Product, error) {
+	products := []Product{}
+	if len(ids) == 0 {
+		return products, nil
+	}
 
-		product.Flavors = []Flavor{}
-		sqlFlavor, err := db.DB.Query(`
-			SELECT id, name
-			FROM flavor a JOIN has_flavor h ON a.id = h.flavor_id
-			WHERE product_id = $1
-		`, product.ID)
-		defer sqlFlavor.Close()
+	rows, err := db.DB.Query("SELECT "+productColumns+" FROM product WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		fmt.Println("Error fetching products:", err)
+		return nil, err
+	}
+	defer rows.Close()
 
+	byID := map[int]*Product{}
+	for rows.Next() {
+		product, err := scanProduct(rows)
 		if err != nil {
-			fmt.Println("sql::", err)
+			fmt.Println("Error scanning product:", err)
+			return nil, err
 		}
+		byID[product.ID] = product
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-		for sqlFlavor.Next() {
-			var flavor Flavor
-			sqlFlavor.Scan(&flavor.ID, &flavor.Name)
-			product.Flavors = append(product.Flavors, flavor)
+	for _, id := range ids {
+		if product, ok := byID[id]; ok {
+			products = append(products, *product)
 		}
+	}
+	if err := loadProductTerms(products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
 
-		product.IdealFors = []IdealFor{}
-		sqlIdeal, err := db.DB.Query(`
-			SELECT id, name
-			FROM ideal_for a JOIN is_ideal_for h ON a.id = h.ideal_for_id
-			WHERE product_id = $1
-		`, product.ID)
-		defer sqlIdeal.Close()
+// loadProductTerms fills the taxonomy terms of the products, one query per
+// taxonomy
+func loadProductTerms(products []Product) error {
+	if len(products) == 0 {
+		return nil
+	}
 
-		if err != nil {
-			fmt.Println("sql::", err)
-		}
+	byID := map[int]*Product{}
+	ids := make([]int, len(products))
+	for i := range products {
+		p := &products[i]
+		p.Flavors, p.Aspects, p.Effects, p.IdealFors, p.Categories = []Flavor{}, []Aspect{}, []Effet{}, []IdealFor{}, []Category{}
+		byID[p.ID] = p
+		ids[i] = p.ID
+	}
 
-		for sqlIdeal.Next() {
-			var idealFor IdealFor
-			err := sqlIdeal.Scan(&idealFor.ID, &idealFor.Name)
-			if err != nil {
-				fmt.Println(err)
-			}
-			product.IdealFors = append(product.IdealFors, idealFor)
+	loaders := []struct {
+		taxonomy taxonomy
+		add      func(p *Product, id, name string)
+	}{
+		{flavorTaxonomy, func(p *Product, id, name string) { p.Flavors = append(p.Flavors, Flavor{id, name}) }},
+		{aspectTaxonomy, func(p *Product, id, name string) { p.Aspects = append(p.Aspects, Aspect{id, name}) }},
+		{effectTaxonomy, func(p *Product, id, name string) { p.Effects = append(p.Effects, Effet{id, name}) }},
+		{idealForTaxonomy, func(p *Product, id, name string) { p.IdealFors = append(p.IdealFors, IdealFor{id, name}) }},
+		{categoryTaxonomy, func(p *Product, id, name string) { p.Categories = append(p.Categories, Category{id, name}) }},
+	}
+	for _, loader := range loaders {
+		if err := loadTaxonomyLinks(loader.taxonomy, ids, func(productID int, id, name string) {
+			loader.add(byID[productID], id, name)
+		}); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		product.Effects = []Effet{}
-		sqlEff, err := db.DB.Query(`
-			SELECT id, name
-			FROM effet a JOIN has_effect h ON a.id = h.effect_id
-			WHERE product_id = $1
-		`, product.ID)
-		defer sqlEff.Close()
-
-		if err != nil {
-			fmt.Println("sql::", err)
-		}
+func loadTaxonomyLinks(t taxonomy, productIDs []int, add func(productID int, id, name string)) error {
+	rows, err := db.DB.Query(`
+		SELECT h.product_id, t.id, t.name
+		FROM `+t.table+` t JOIN `+t.link+` h ON t.id = h.`+t.column+`
+		WHERE h.product_id = ANY($1)
+		ORDER BY h.product_id, t.name
+	`, pq.Array(productIDs))
+	if err != nil {
+		fmt.Println("Error fetching product "+t.table+":", err)
+		return err
+	}
+	defer rows.Close()
 
-		for sqlEff.Next() {
-			var effect Effet
-			sqlEff.Scan(&effect.ID, &effect.Name)
-			product.Effects = append(product.Effects, effect)
+	for rows.Next() {
+		var productID int
+		var id, name string
+		if err := rows.Scan(&productID, &id, &name); err != nil {
+			return err
 		}
-
-		products = append(products, product)
+		add(productID, id, name)
 	}
-	fmt.Println(products)
-	return products, nil
+	return rows.Err()
 }
 
 func ChangeImagePath(productID, imagePath string) error {
@@ -172,29 +198,6 @@ func ChangeImagePath(productID, imagePath string) error {
 	return err
 }
 
-func GetProductsByConditions(conditions string) ([]Product, error) {
-	var products []Product
-	// Prepare the SQL query with conditions
-	query := fmt.Sprintf("SELECT * FROM product WHERE %s", conditions)
-	rows, err := db.DB.Query(query)
-	rows.Scan(&products)
-	if err != nil {
-		return nil, err
-	}
-
-	jsonData, err := json.Marshal(products)
-	if err != nil {
-		return nil, err
-	}
-
-	var result []Product
-	err = json.Unmarshal(jsonData, &result)
-	if err != nil {
-		return nil, err
-	}
-	return result, nil
-}
-
 func AddProduct(product *Product) (int, error) {
 	query := "INSERT INTO product (name, genetics, star, type, stock, thc_rate, cbd_rate, price, image, description, rating, color) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id"
 	row := db.DB.QueryRow(query, product.Name, product.Genetics, product.Star, product.Type, product.Stock, product.Thc_rate, product.Cbd_rate, product.Price, product.Image, product.Description, product.Rating, product.Color)
@@ -204,245 +207,179 @@ func AddProduct(product *Product) (int, error) {
 		return 0, err
 	}
 
-	// Flavors
-	for _, v := range product.Flavors {
-		var id int
-		err := db.DB.QueryRow(`
-			INSERT INTO flavor (name)
-			SELECT $1
-			RETURNING id
-		`, v.Name).Scan(&id)
-
-		// si déjà existant
-		if err != nil {
-			err = db.DB.QueryRow(`SELECT id FROM flavor WHERE name = $1`, v.Name).Scan(&id)
+	for _, terms := range productTermNames(product) {
+		for _, name := range terms.names {
+			id, err := ensureTaxonomyTerm(db.DB, terms.taxonomy, name)
 			if err != nil {
-				fmt.Println("Erreur récupération flavor existant :", v.Name, err)
+				fmt.Println("Error adding "+terms.taxonomy.table+":", name, err)
 				continue
 			}
-		}
 
-		_, err = db.DB.Exec(`INSERT INTO has_flavor (product_id, flavor_id) VALUES ($1, $2)`, productID, id)
-		if err != nil {
-			fmt.Println("Erreur liaison flavor :", err)
-		}
-	}
-
-	// Aspects
-	for _, v := range product.Aspects {
-		var id int
-		err := db.DB.QueryRow(`
-			INSERT INTO aspect (name)
-			SELECT $1
-			RETURNING id
-		`, v.Name).Scan(&id)
-
-		if err != nil {
-			err = db.DB.QueryRow(`SELECT id FROM aspect WHERE name = $1`, v.Name).Scan(&id)
+			_, err = db.DB.Exec("INSERT INTO "+terms.taxonomy.link+" (product_id, "+terms.taxonomy.column+") VALUES ($1, $2)", productID, id)
 			if err != nil {
-				fmt.Println("Erreur récupération aspect existant :", v.Name, err)
-				continue
+				fmt.Println("Error linking "+terms.taxonomy.table+":", err)
 			}
 		}
-
-		_, err = db.DB.Exec(`INSERT INTO has_aspect (product_id, aspect_id) VALUES ($1, $2)`, productID, id)
-		if err != nil {
-			fmt.Println("Erreur liaison aspect :", err)
-		}
 	}
 
-	for _, v := range product.Categories {
-		var id int
-		err := db.DB.QueryRow(`
-			INSERT INTO category (name)
-			SELECT $1
-			RETURNING id
-		`, v.Name).Scan(&id)
+	return productID, nil
+}
 
-		if err != nil {
-			err = db.DB.QueryRow(`SELECT id FROM category WHERE name = $1`, v.Name).Scan(&id)
-			if err != nil {
-				fmt.Println("Erreur récupération category existant :", v.Name, err)
-				continue
-			}
-		}
+type taxonomyNames struct {
+	taxonomy taxonomy
+	names    []string
+}
 
-		_, err = db.DB.Exec(`INSERT INTO belongs_to (product_id, category_ido) VALUES ($1, $2)`, productID, id)
-		if err != nil {
-			fmt.Println("Erreur liaison aspect :", err)
-		}
+// productTermNames returns the normalized names of the taxonomy terms of
+// the product, without duplicates
+func productTermNames(product *Product) []taxonomyNames {
+	var flavors, aspects, effects, idealFors, categories []string
+	for _, v := range product.Flavors {
+		flavors = append(flavors, v.Name)
+	}
+	for _, v := range product.Aspects {
+		aspects = append(aspects, v.Name)
 	}
-
-	// Effects
 	for _, v := range product.Effects {
-		var id int
-		err := db.DB.QueryRow(`
-			INSERT INTO effet (name)
-			SELECT $1
-			RETURNING id
-		`, v.Name).Scan(&id)
-
-		if err != nil {
-			err = db.DB.QueryRow(`SELECT id FROM effet WHERE name = $1`, v.Name).Scan(&id)
-			if err != nil {
-				fmt.Println("Erreur récupération effet existant :", v.Name, err)
-				continue
-			}
-		}
-
-		_, err = db.DB.Exec(`INSERT INTO has_effect (product_id, effect_id) VALUES ($1, $2)`, productID, id)
-		if err != nil {
-			fmt.Println("Erreur liaison effet :", err)
-		}
+		effects = append(effects, v.Name)
 	}
-
-	// Ideal for
 	for _, v := range product.IdealFors {
-		var id int
-		err := db.DB.QueryRow(`
-			INSERT INTO ideal_for (name)
-			SELECT $1
-			RETURNING id
-		`, v.Name).Scan(&id)
+		idealFors = append(idealFors, v.Name)
+	}
+	for _, v := range product.Categories {
+		categories = append(categories, v.Name)
+	}
 
-		if err != nil {
-			err = db.DB.QueryRow(`SELECT id FROM ideal_for WHERE name = $1`, v.Name).Scan(&id)
-			if err != nil {
-				fmt.Println("Erreur récupération ideal_for existant :", v.Name, err)
+	terms := []taxonomyNames{
+		{flavorTaxonomy, flavors},
+		{aspectTaxonomy, aspects},
+		{effectTaxonomy, effects},
+		{idealForTaxonomy, idealFors},
+		{categoryTaxonomy, categories},
+	}
+	for i := range terms {
+		seen := map[string]bool{}
+		var names []string
+		for _, name := range terms[i].names {
+			name = NormalizeTermName(name)
+			if name == "" || seen[strings.ToLower(name)] {
 				continue
 			}
+			seen[strings.ToLower(name)] = true
+			names = append(names, name)
 		}
-
-		_, err = db.DB.Exec(`INSERT INTO is_ideal_for (product_id, ideal_for_id) VALUES ($1, $2)`, productID, id)
-		if err != nil {
-			fmt.Println("Erreur liaison ideal_for :", err)
-		}
+		terms[i].names = names
 	}
-
-	return productID, nil
+	return terms
 }
 
-func UpdateProduct(product *Product) error {
-	query := "UPDATE product SET name = $1, genetics = $2, star = $3, type = $4, thc_rate = $5, cbd_rate = $6, price = $7, description = $8, color = $9 WHERE id = $10"
-	_, err := db.DB.Exec(query, product.Name, product.Genetics, product.Star, product.Type, product.Thc_rate, product.Cbd_rate, product.Price, product.Description, product.Color, product.ID)
-	return err
+// ProductError names the field of a product that is missing or malformed
+type ProductError struct {
+	Field string
 }
 
-func DeleteProduct(id string) error {
-	query := "DELETE FROM product WHERE id = $1"
-	_, err := db.DB.Exec(query, id)
-	return err
+func (e *ProductError) Error() string {
+	return "invalid product field: " + e.Field
 }
 
-func GetProductByID(id string) (*Product, error) {
-	fmt.Println("id", id)
-	query := "SELECT * FROM product WHERE id = $1"
-	sql := db.DB.QueryRow(query, id)
-
-	var product Product
-	err := sql.Scan(&product.ID, &product.Name, &product.Genetics, &product.Star, &product.Type, &product.Stock, &product.Thc_rate, &product.Cbd_rate, &product.Price, &product.Image, &product.Description, &product.Rating, &product.Color)
-
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
+func (p *Product) Validate() error {
+	switch {
+	case strings.TrimSpace(p.Name) == "":
+		return &ProductError{Field: "name"}
+	case p.Stock < 0:
+		return &ProductError{Field: "stock"}
+	case p.Price.LessThan(money.New(0)):
+		return &ProductError{Field: "price"}
+	case p.Thc_rate < 0 || p.Thc_rate > 100:
+		return &ProductError{Field: "thc_rate"}
+	case p.Cbd_rate < 0 || p.Cbd_rate > 100:
+		return &ProductError{Field: "cbd_rate"}
+	case p.Rating < 0:
+		return &ProductError{Field: "rating"}
 	}
+	return nil
+}
 
-	product.Categories = []Category{}
-	sqlCategory, err := db.DB.Query(`
-			SELECT id, name
-			FROM aspect a JOIN has_aspect h ON a.id = h.aspect_id
-			WHERE product_id = $1
-		`, product.ID)
-	defer sqlCategory.Close()
-
+// UpdateProduct replaces every column of the product and its taxonomy terms,
+// in one transaction
+func UpdateProduct(product *Product) error {
+	tx, err := db.DB.Begin()
 	if err != nil {
-		fmt.Println("sql::", err)
+		return err
 	}
+	defer tx.Rollback()
 
-	for sqlCategory.Next() {
-		var category Category
-		sqlCategory.Scan(&category.ID, &category.Name)
-		product.Categories = append(product.Categories, category)
-	}
-
-	product.Aspects = []Aspect{}
-	sqlAspect, err := db.DB.Query(`
-			SELECT id, name
-			FROM aspect a JOIN has_aspect h ON a.id = h.aspect_id
-			WHERE product_id = $1
-		`, product.ID)
-	defer sqlAspect.Close()
-
+	res, err := tx.Exec(`
+		UPDATE product
+		SET name = $2, genetics = $3, star = $4, type = $5, stock = $6, thc_rate = $7, cbd_rate = $8, price = $9, image = $10, description = $11, rating = $12, color = $13
+		WHERE id = $1
+	`, product.ID, product.Name, product.Genetics, product.Star, product.Type, product.Stock, product.Thc_rate, product.Cbd_rate, product.Price, product.Image, product.Description, product.Rating, product.Color)
 	if err != nil {
-		fmt.Println("sql::", err)
+		fmt.Println("Error updating product:", err)
+		return err
 	}
-
-	for sqlAspect.Next() {
-		var aspect Aspect
-		sqlAspect.Scan(&aspect.ID, &aspect.Name)
-		product.Aspects = append(product.Aspects, aspect)
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrProductNotFound
 	}
 
-	product.Flavors = []Flavor{}
-	sqlFlavor, err := db.DB.Query(`
-			SELECT id, name
-			FROM flavor a JOIN has_flavor h ON a.id = h.flavor_id
-			WHERE product_id = $1
-		`, product.ID)
-	defer sqlFlavor.Close()
-
-	if err != nil {
-		fmt.Println("sql::", err)
-		return nil, err
+	for _, terms := range productTermNames(product) {
+		if err := setProductTerms(tx, product.ID, terms); err != nil {
+			return err
+		}
 	}
 
-	for sqlFlavor.Next() {
-		var flavor Flavor
-		sqlFlavor.Scan(&flavor.ID, &flavor.Name)
-		product.Flavors = append(product.Flavors, flavor)
-	}
+	return tx.Commit()
+}
 
-	product.IdealFors = []IdealFor{}
-	sqlIdeal, err := db.DB.Query(`
-			SELECT id, name
-			FROM ideal_for a JOIN is_ideal_for h ON a.id = h.ideal_for_id
-			WHERE product_id = $1
-		`, product.ID)
-	defer sqlIdeal.Close()
+// setProductTerms links the product to exactly the named terms of the
+// taxonomy, creating the missing terms and leaving unchanged links alone
+func setProductTerms(tx *sql.Tx, productID int, terms taxonomyNames) error {
+	t := terms.taxonomy
+	ids := make([]int, 0, len(terms.names))
+	for _, name := range terms.names {
+		id, err := ensureTaxonomyTerm(tx, t, name)
+		if err != nil {
+			fmt.Println("Error adding "+t.table+":", name, err)
+			return err
+		}
+		ids = append(ids, id)
+	}
 
+	_, err := tx.Exec("DELETE FROM "+t.link+" WHERE product_id = $1 AND NOT ("+t.column+" = ANY($2))", productID, pq.Array(ids))
 	if err != nil {
-		fmt.Println("sql::", err)
-		return nil, err
+		fmt.Println("Error unlinking "+t.table+":", err)
+		return err
 	}
-
-	for sqlIdeal.Next() {
-		var idealFor IdealFor
-		err := sqlIdeal.Scan(&idealFor.ID, &idealFor.Name)
-		if err != nil {
-			fmt.Println(err)
-			return nil, err
-		}
-		product.IdealFors = append(product.IdealFors, idealFor)
+	_, err = tx.Exec(`
+		INSERT INTO `+t.link+` (product_id, `+t.column+`)
+		SELECT $1, u.term_id FROM unnest($2::INTEGER[]) AS u (term_id)
+		WHERE NOT EXISTS (SELECT 1 FROM `+t.link+` e WHERE e.product_id = $1 AND e.`+t.column+` = u.term_id)
+	`, productID, pq.Array(ids))
+	if err != nil {
+		fmt.Println("Error linking "+t.table+":", err)
 	}
+	return err
+}
 
-	product.Effects = []Effet{}
-	sqlEff, err := db.DB.Query(`
-			SELECT id, name
-			FROM effet a JOIN has_effect h ON a.id = h.effect_id
-			WHERE product_id = $1
-		`, product.ID)
-	defer sqlEff.Close()
+func DeleteProduct(id string) error {
+	query := "DELETE FROM product WHERE id = $1"
+	_, err := db.DB.Exec(query, id)
+	return err
+}
 
+func GetProductByID(id string) (*Product, error) {
+	product, err := scanProduct(db.DB.QueryRow("SELECT "+productColumns+" FROM product WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrProductNotFound
+	}
 	if err != nil {
-		fmt.Println("sql::", err)
+		fmt.Println("Error fetching product:", err)
 		return nil, err
 	}
 
-	for sqlEff.Next() {
-		var effect Effet
-		sqlEff.Scan(&effect.ID, &effect.Name)
-		product.Effects = append(product.Effects, effect)
+	products := []Product{*product}
+	if err := loadProductTerms(products); err != nil {
+		return nil, err
 	}
-
-	return &product, nil
+	return &products[0], nil
 }
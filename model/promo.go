@@ -0,0 +1,437 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sec-app-server/db"
+	"sec-app-server/money"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	PromoPercent = "percent"
+	PromoFixed   = "fixed"
+)
+
+var (
+	ErrPromoNotFound  = errors.New("promo code not found")
+	ErrPromoCodeTaken = errors.New("promo code already exists")
+)
+
+// PromoCode is a discount customers get by entering Code. Without products
+// nor categories it applies to the whole cart, otherwise to the lines of the
+// listed products and of the products in the listed categories.
+type PromoCode struct {
+	ID   int    `json:"id"`
+	Code string `json:"code"`
+	// Kind is PromoPercent, PercentBP being the basis points taken off (1250
+	// for 12.5%), or PromoFixed, Amount being taken off
+	Kind      string      `json:"kind"`
+	PercentBP int64       `json:"percent_bp"`
+	Amount    money.Money `json:"amount"`
+	MinBasket money.Money `json:"min_basket"`
+	// Currency of Amount and MinBasket, the one of the shop when the code was
+	// last saved. The code is refused on carts in another currency.
+	Currency    string  `json:"currency"`
+	ProductIDs  []int64 `json:"product_ids"`
+	CategoryIDs []int64 `json:"category_ids"`
+	// MaxUses and MaxUsesPerUser are unlimited when nil
+	MaxUses        *int       `json:"max_uses"`
+	MaxUsesPerUser *int       `json:"max_uses_per_user"`
+	StartsAt       *time.Time `json:"starts_at"`
+	EndsAt         *time.Time `json:"ends_at"`
+	Active         bool       `json:"active"`
+	Uses           int        `json:"uses"`
+}
+
+// PromoError names the field of a promo code that is missing or malformed
+type PromoError struct {
+	Field string
+}
+
+func (e *PromoError) Error() string {
+	return "invalid promo code field: " + e.Field
+}
+
+// PromoRejectedError tells why a promo code cannot be used on a cart
+type PromoRejectedError struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+func (e *PromoRejectedError) Error() string {
+	return fmt.Sprintf("promo code %s cannot be used: %s", e.Code, e.Reason)
+}
+
+func (p *PromoCode) Normalize() {
+	p.Code = strings.ToUpper(strings.TrimSpace(p.Code))
+	// only the value of the kind is kept
+	switch p.Kind {
+	case PromoPercent:
+		p.Amount = money.New(0)
+	case PromoFixed:
+		p.PercentBP = 0
+	}
+	if p.ProductIDs == nil {
+		p.ProductIDs = []int64{}
+	}
+	if p.CategoryIDs == nil {
+		p.CategoryIDs = []int64{}
+	}
+}
+
+func (p *PromoCode) Validate() error {
+	switch {
+	case p.Code == "" || len(p.Code) > 32:
+		return &PromoError{Field: "code"}
+	case p.Kind != PromoPercent && p.Kind != PromoFixed:
+		return &PromoError{Field: "kind"}
+	case p.Kind == PromoPercent && (p.PercentBP <= 0 || p.PercentBP > 10000):
+		return &PromoError{Field: "percent_bp"}
+	case p.Kind == PromoFixed && !money.New(0).LessThan(p.Amount):
+		return &PromoError{Field: "amount"}
+	case p.MinBasket.LessThan(money.New(0)):
+		return &PromoError{Field: "min_basket"}
+	case p.MaxUses != nil && *p.MaxUses < 1:
+		return &PromoError{Field: "max_uses"}
+	case p.MaxUsesPerUser != nil && *p.MaxUsesPerUser < 1:
+		return &PromoError{Field: "max_uses_per_user"}
+	case p.StartsAt != nil && p.EndsAt != nil && !p.EndsAt.After(*p.StartsAt):
+		return &PromoError{Field: "ends_at"}
+	}
+	return nil
+}
+
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+const promoColumns = `p.id, p.code, p.kind, COALESCE(p.percent_bp, 0), p.amount, p.min_basket, p.currency, p.product_ids, p.category_ids, p.max_uses, p.max_uses_per_user, p.starts_at, p.ends_at, p.active,
+	(SELECT COUNT(*) FROM promo_redemptions r WHERE r.promo_code_id = p.id)`
+
+func scanPromo(row interface{ Scan(...any) error }) (*PromoCode, error) {
+	var p PromoCode
+	var amount, minBasket string
+	var maxUses, maxUsesPerUser sql.NullInt64
+	var startsAt, endsAt sql.NullTime
+	err := row.Scan(&p.ID, &p.Code, &p.Kind, &p.PercentBP, &amount, &minBasket, &p.Currency, pq.Array(&p.ProductIDs), pq.Array(&p.CategoryIDs),
+		&maxUses, &maxUsesPerUser, &startsAt, &endsAt, &p.Active, &p.Uses)
+	if err != nil {
+		return nil, err
+	}
+	if p.Amount, err = money.ParseIn(amount, p.Currency); err != nil {
+		return nil, err
+	}
+	if p.MinBasket, err = money.ParseIn(minBasket, p.Currency); err != nil {
+		return nil, err
+	}
+	if maxUses.Valid {
+		n := int(maxUses.Int64)
+		p.MaxUses = &n
+	}
+	if maxUsesPerUser.Valid {
+		n := int(maxUsesPerUser.Int64)
+		p.MaxUsesPerUser = &n
+	}
+	if startsAt.Valid {
+		p.StartsAt = &startsAt.Time
+	}
+	if endsAt.Valid {
+		p.EndsAt = &endsAt.Time
+	}
+	p.Normalize()
+	return &p, nil
+}
+
+func GetPromoCodes() ([]PromoCode, error) {
+	promos := []PromoCode{}
+	rows, err := db.DB.Query("SELECT " + promoColumns + " FROM promo_codes p ORDER BY p.id")
+	if err != nil {
+		fmt.Println("Error fetching promo codes:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		promo, err := scanPromo(rows)
+		if err != nil {
+			return nil, err
+		}
+		promos = append(promos, *promo)
+	}
+	return promos, rows.Err()
+}
+
+func getPromoCode(q queryer, condition string, arg any) (*PromoCode, error) {
+	promo, err := scanPromo(q.QueryRow("SELECT "+promoColumns+" FROM promo_codes p WHERE "+condition, arg))
+	if err == sql.ErrNoRows {
+		return nil, ErrPromoNotFound
+	}
+	if err != nil {
+		fmt.Println("Error fetching promo code:", err)
+		return nil, err
+	}
+	return promo, nil
+}
+
+func GetPromoCode(id string) (*PromoCode, error) {
+	return getPromoCode(db.DB, "p.id = $1", id)
+}
+
+// AddPromoCode creates a validated promo code, ErrPromoCodeTaken if the code
+// exists already. Its amounts are in the currency of the shop.
+func AddPromoCode(promo *PromoCode) error {
+	promo.Currency = money.DefaultCurrency
+	err := db.DB.QueryRow(`
+		INSERT INTO promo_codes (code, kind, percent_bp, amount, min_basket, currency, product_ids, category_ids, max_uses, max_uses_per_user, starts_at, ends_at, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id
+	`, promo.Code, promo.Kind, promo.PercentBP, promo.Amount, promo.MinBasket, promo.Currency, pq.Array(promo.ProductIDs), pq.Array(promo.CategoryIDs),
+		promo.MaxUses, promo.MaxUsesPerUser, promo.StartsAt, promo.EndsAt, promo.Active).Scan(&promo.ID)
+	if isUniqueViolation(err) {
+		return ErrPromoCodeTaken
+	}
+	if err != nil {
+		fmt.Println("Error adding promo code:", err)
+	}
+	return err
+}
+
+func UpdatePromoCode(promo *PromoCode) error {
+	promo.Currency = money.DefaultCurrency
+	res, err := db.DB.Exec(`
+		UPDATE promo_codes
+		SET code = $2, kind = $3, percent_bp = $4, amount = $5, min_basket = $6, currency = $7, product_ids = $8, category_ids = $9,
+			max_uses = $10, max_uses_per_user = $11, starts_at = $12, ends_at = $13, active = $14
+		WHERE id = $1
+	`, promo.ID, promo.Code, promo.Kind, promo.PercentBP, promo.Amount, promo.MinBasket, promo.Currency, pq.Array(promo.ProductIDs), pq.Array(promo.CategoryIDs),
+		promo.MaxUses, promo.MaxUsesPerUser, promo.StartsAt, promo.EndsAt, promo.Active)
+	if isUniqueViolation(err) {
+		return ErrPromoCodeTaken
+	}
+	if err != nil {
+		fmt.Println("Error updating promo code:", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrPromoNotFound
+	}
+	return nil
+}
+
+// DeletePromoCode removes a code from the carts it was applied to, orders
+// keep their discount lines
+func DeletePromoCode(id string) error {
+	res, err := db.DB.Exec("DELETE FROM promo_codes WHERE id = $1", id)
+	if err != nil {
+		fmt.Println("Error deleting promo code:", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrPromoNotFound
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// pricedLine is a cart line at the price it is sold
+type pricedLine struct {
+	productID int
+	price     money.Money
+	quantity  int
+}
+
+// promoDiscount checks that the promo can be used by the user on the lines
+// and returns the amount it takes off
+func promoDiscount(q queryer, promo *PromoCode, userID string, lines []pricedLine, now time.Time) (money.Money, error) {
+	reject := func(reason string) (money.Money, error) {
+		return money.New(0), &PromoRejectedError{Code: promo.Code, Reason: reason}
+	}
+
+	switch {
+	case !promo.Active:
+		return reject("inactive")
+	case promo.StartsAt != nil && now.Before(*promo.StartsAt):
+		return reject("not-started")
+	case promo.EndsAt != nil && !now.Before(*promo.EndsAt):
+		return reject("expired")
+	case promo.MaxUses != nil && promo.Uses >= *promo.MaxUses:
+		return reject("usage-limit")
+	}
+
+	if promo.MaxUsesPerUser != nil {
+		var uses int
+		err := q.QueryRow("SELECT COUNT(*) FROM promo_redemptions WHERE promo_code_id = $1 AND user_id = $2", promo.ID, userID).Scan(&uses)
+		if err != nil {
+			fmt.Println("Error counting promo uses:", err)
+			return money.New(0), err
+		}
+		if uses >= *promo.MaxUsesPerUser {
+			return reject("user-limit")
+		}
+	}
+
+	subtotal := money.New(0)
+	for _, l := range lines {
+		subtotal = subtotal.Add(l.price.Mul(l.quantity))
+	}
+	if promo.Currency != subtotal.Currency {
+		return reject("currency")
+	}
+	if subtotal.LessThan(promo.MinBasket) {
+		return reject("min-basket")
+	}
+
+	eligible, err := eligibleProducts(q, promo, lines)
+	if err != nil {
+		return money.New(0), err
+	}
+	eligibleTotal := money.New(0)
+	for _, l := range lines {
+		if eligible[l.productID] {
+			eligibleTotal = eligibleTotal.Add(l.price.Mul(l.quantity))
+		}
+	}
+	if eligibleTotal.IsZero() {
+		return reject("no-eligible-product")
+	}
+
+	if promo.Kind == PromoPercent {
+		return eligibleTotal.Percent(promo.PercentBP), nil
+	}
+	return promo.Amount.Min(eligibleTotal), nil
+}
+
+// eligibleProducts returns the products of the lines the promo applies to
+func eligibleProducts(q queryer, promo *PromoCode, lines []pricedLine) (map[int]bool, error) {
+	eligible := map[int]bool{}
+	scoped := len(promo.ProductIDs) > 0 || len(promo.CategoryIDs) > 0
+
+	ids := make([]int64, len(lines))
+	for i, l := range lines {
+		ids[i] = int64(l.productID)
+		if !scoped {
+			eligible[l.productID] = true
+		}
+	}
+	if !scoped {
+		return eligible, nil
+	}
+
+	rows, err := q.Query(`
+		SELECT p.id FROM product p
+		WHERE p.id = ANY($1) AND (
+			p.id = ANY($2)
+			OR EXISTS (SELECT 1 FROM belongs_to b WHERE b.product_id = p.id AND b.category_id = ANY($3))
+		)
+	`, pq.Array(ids), pq.Array(promo.ProductIDs), pq.Array(promo.CategoryIDs))
+	if err != nil {
+		fmt.Println("Error fetching promo products:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		eligible[id] = true
+	}
+	return eligible, rows.Err()
+}
+
+// ApplyCartPromo checks the code against the cart of the user and keeps it
+// for the checkout, replacing the code applied before if any
+func ApplyCartPromo(userID, code string) (*PromoCode, money.Money, error) {
+	promo, err := getPromoCode(db.DB, "p.code = $1", strings.ToUpper(strings.TrimSpace(code)))
+	if err != nil {
+		return nil, money.Money{}, err
+	}
+
+	lines, err := cartLines(db.DB, userID)
+	if err != nil {
+		return nil, money.Money{}, err
+	}
+	if len(lines) == 0 {
+		return nil, money.Money{}, ErrCartEmpty
+	}
+
+	discount, err := promoDiscount(db.DB, promo, userID, lines, time.Now())
+	if err != nil {
+		return nil, money.Money{}, err
+	}
+
+	_, err = db.DB.Exec(`
+		INSERT INTO cart_promo (user_id, promo_code_id) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET promo_code_id = EXCLUDED.promo_code_id
+	`, userID, promo.ID)
+	if err != nil {
+		fmt.Println("Error applying promo code:", err)
+		return nil, money.Money{}, err
+	}
+	return promo, discount, nil
+}
+
+func RemoveCartPromo(userID string) error {
+	_, err := db.DB.Exec("DELETE FROM cart_promo WHERE user_id = $1", userID)
+	if err != nil {
+		fmt.Println("Error removing promo code:", err)
+	}
+	return err
+}
+
+// cartLines returns the lines of the cart at the current prices
+func cartLines(q queryer, userID string) ([]pricedLine, error) {
+	rows, err := q.Query(`
+		SELECT p.id, p.price, c.quantity
+		FROM cart c JOIN product p ON p.id = c.product_id
+		WHERE c.user_id = $1
+	`, userID)
+	if err != nil {
+		fmt.Println("Error fetching cart items:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []pricedLine
+	for rows.Next() {
+		var l pricedLine
+		if err := rows.Scan(&l.productID, &l.price, &l.quantity); err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	return lines, rows.Err()
+}
+
+// cartPromo returns the promo code applied to the cart, nil if none. In a
+// transaction, lock takes the code first so that its usage limit holds
+// against concurrent checkouts.
+func cartPromo(q queryer, userID string, lock bool) (*PromoCode, error) {
+	var id int
+	query := "SELECT p.id FROM cart_promo c JOIN promo_codes p ON p.id = c.promo_code_id WHERE c.user_id = $1"
+	if lock {
+		query += " FOR UPDATE OF p"
+	}
+	err := q.QueryRow(query, userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		fmt.Println("Error fetching cart promo code:", err)
+		return nil, err
+	}
+
+	// counted after the lock is held
+	return getPromoCode(q, "p.id = $1", id)
+}
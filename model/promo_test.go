@@ -0,0 +1,41 @@
+package model
+
+import (
+	"sec-app-server/money"
+	"testing"
+)
+
+func TestPromoCodeValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		promo PromoCode
+		field string
+	}{
+		{"percent", PromoCode{Code: "SPRING", Kind: PromoPercent, PercentBP: 1250}, ""},
+		{"whole cart off", PromoCode{Code: "FREE", Kind: PromoPercent, PercentBP: 10000}, ""},
+		{"fixed", PromoCode{Code: "FIVE", Kind: PromoFixed, Amount: money.New(500)}, ""},
+		{"no code", PromoCode{Kind: PromoFixed, Amount: money.New(500)}, "code"},
+		{"unknown kind", PromoCode{Code: "X", Kind: "gift"}, "kind"},
+		{"no percent", PromoCode{Code: "X", Kind: PromoPercent}, "percent_bp"},
+		{"over 100%", PromoCode{Code: "X", Kind: PromoPercent, PercentBP: 10001}, "percent_bp"},
+		{"amount of a percent", PromoCode{Code: "X", Kind: PromoPercent, Amount: money.New(500)}, "percent_bp"},
+		{"no amount", PromoCode{Code: "X", Kind: PromoFixed, PercentBP: 1000}, "amount"},
+		{"negative amount", PromoCode{Code: "X", Kind: PromoFixed, Amount: money.New(-500)}, "amount"},
+		{"negative min basket", PromoCode{Code: "X", Kind: PromoFixed, Amount: money.New(500), MinBasket: money.New(-1)}, "min_basket"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.promo.Normalize()
+			err := tt.promo.Validate()
+			field := ""
+			if promoErr, ok := err.(*PromoError); ok {
+				field = promoErr.Field
+			} else if err != nil {
+				t.Fatalf("Validate = %v", err)
+			}
+			if field != tt.field {
+				t.Errorf("Validate rejected %q, want %q", field, tt.field)
+			}
+		})
+	}
+}
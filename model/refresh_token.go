@@ -0,0 +1,139 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sec-app-server/db"
+	"sec-app-server/utils"
+	"time"
+)
+
+const RefreshTokenLifetime = 30 * 24 * time.Hour
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+)
+
+// CreateRefreshToken stores a new refresh token for the user and returns its
+// clear value. An empty familyID starts a new rotation chain.
+func CreateRefreshToken(email, familyID string) (string, error) {
+	return createRefreshToken(db.DB, email, familyID)
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func createRefreshToken(ex execer, email, familyID string) (string, error) {
+	if familyID == "" {
+		familyID = utils.GenerateToken(16)
+	}
+	token := utils.GenerateToken(utils.DefaultTokenBytes)
+
+	_, err := ex.Exec(`
+		INSERT INTO refresh_tokens (token_hash, family_id, user_email, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, utils.HashString(token), familyID, email, time.Now(), time.Now().Add(RefreshTokenLifetime))
+	if err != nil {
+		fmt.Println("Error creating refresh token:", err)
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken consumes a refresh token and issues its successor in the
+// same chain. Presenting a token that was already consumed revokes the whole
+// chain, since it means it leaked.
+func RotateRefreshToken(token string) (email string, newToken string, err error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	var familyID string
+	var expiresAt time.Time
+	var usedAt, revokedAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT family_id, user_email, expires_at, used_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+	`, utils.HashString(token)).Scan(&familyID, &email, &expiresAt, &usedAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return "", "", ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		fmt.Println("Error fetching refresh token:", err)
+		return "", "", err
+	}
+
+	state := refreshTokenState(usedAt, revokedAt, expiresAt, time.Now())
+	if state == ErrRefreshTokenReused {
+		if _, err := tx.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL", familyID); err != nil {
+			fmt.Println("Error revoking refresh token family:", err)
+			return "", "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return "", "", err
+		}
+		return "", "", ErrRefreshTokenReused
+	}
+
+	if state != nil {
+		return "", "", state
+	}
+
+	if _, err := tx.Exec("UPDATE refresh_tokens SET used_at = NOW() WHERE token_hash = $1", utils.HashString(token)); err != nil {
+		fmt.Println("Error consuming refresh token:", err)
+		return "", "", err
+	}
+
+	newToken, err = createRefreshToken(tx, email, familyID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+	return email, newToken, nil
+}
+
+// refreshTokenState tells whether a stored refresh token can be rotated:
+// ErrRefreshTokenReused if it was consumed or revoked, which matters more than
+// its expiry, ErrRefreshTokenExpired, or nil
+func refreshTokenState(usedAt, revokedAt sql.NullTime, expiresAt, now time.Time) error {
+	if usedAt.Valid || revokedAt.Valid {
+		return ErrRefreshTokenReused
+	}
+	if now.After(expiresAt) {
+		return ErrRefreshTokenExpired
+	}
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes the chain the given refresh token of the
+// user belongs to
+func RevokeRefreshTokenFamily(email, token string) error {
+	_, err := db.DB.Exec(`
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE revoked_at IS NULL
+		AND family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1 AND user_email = $2)
+	`, utils.HashString(token), email)
+	if err != nil {
+		fmt.Println("Error revoking refresh token:", err)
+	}
+	return err
+}
+
+func RevokeUserRefreshTokens(email string) error {
+	_, err := db.DB.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_email = $1 AND revoked_at IS NULL", email)
+	if err != nil {
+		fmt.Println("Error revoking user refresh tokens:", err)
+	}
+	return err
+}
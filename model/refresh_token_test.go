@@ -0,0 +1,33 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokenState(t *testing.T) {
+	now := time.Now()
+	used := sql.NullTime{Time: now.Add(-time.Minute), Valid: true}
+
+	tests := []struct {
+		name      string
+		usedAt    sql.NullTime
+		revokedAt sql.NullTime
+		expiresAt time.Time
+		want      error
+	}{
+		{"fresh", sql.NullTime{}, sql.NullTime{}, now.Add(time.Hour), nil},
+		{"expired", sql.NullTime{}, sql.NullTime{}, now.Add(-time.Second), ErrRefreshTokenExpired},
+		{"already used", used, sql.NullTime{}, now.Add(time.Hour), ErrRefreshTokenReused},
+		{"revoked", sql.NullTime{}, used, now.Add(time.Hour), ErrRefreshTokenReused},
+		{"used and expired is still a reuse", used, sql.NullTime{}, now.Add(-time.Hour), ErrRefreshTokenReused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refreshTokenState(tt.usedAt, tt.revokedAt, tt.expiresAt, now); got != tt.want {
+				t.Errorf("refreshTokenState = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
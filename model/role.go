@@ -0,0 +1,198 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sec-app-server/db"
+
+	"github.com/lib/pq"
+)
+
+// Permissions checked by middlewares.RequirePermission
+const (
+	PermProductWrite = "product:write"
+	PermFAQWrite     = "faq:write"
+	PermUserRead     = "user:read"
+	PermUserWrite    = "user:write"
+	PermOrderRead    = "order:read"
+	PermOrderWrite   = "order:write"
+	PermPromoWrite   = "promo:write"
+	PermLogRead      = "log:read"
+	PermLogWrite     = "log:write"
+	PermRoleWrite    = "role:write"
+)
+
+// Built-in roles, seeded by the schema migration
+const (
+	RoleSuperAdmin    = "super-admin"
+	RoleCatalogEditor = "catalog-editor"
+	RoleSupport       = "support"
+	RoleAuditor       = "auditor"
+)
+
+var ErrUnknownRole = errors.New("unknown role")
+
+type Role struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+func GetRoles() ([]Role, error) {
+	roles := []Role{}
+	rows, err := db.DB.Query(`
+		SELECT r.id, r.name, r.description, COALESCE(array_agg(rp.permission ORDER BY rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}')
+		FROM roles r LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		GROUP BY r.id
+		ORDER BY r.id
+	`)
+	if err != nil {
+		fmt.Println("Error fetching roles:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, pq.Array(&role.Permissions)); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// GetUserRoles returns the role names of the user, by hashed email
+func GetUserRoles(email string) ([]string, error) {
+	roles := []string{}
+	rows, err := db.DB.Query(`
+		SELECT r.name
+		FROM roles r JOIN user_roles ur ON ur.role_id = r.id JOIN users u ON u.id = ur.user_id
+		WHERE u.email = $1
+		ORDER BY r.name
+	`, email)
+	if err != nil {
+		fmt.Println("Error fetching user roles:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// SetUserRoles replaces the roles of the user. users.is_admin is kept as
+// "has any staff role" for the clients that still read it.
+func SetUserRoles(userID string, roles []string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var known int
+	err = tx.QueryRow("SELECT COUNT(*) FROM roles WHERE name = ANY($1)", pq.Array(roles)).Scan(&known)
+	if err != nil {
+		fmt.Println("Error checking roles:", err)
+		return err
+	}
+	if known != len(uniqueStrings(roles)) {
+		return ErrUnknownRole
+	}
+
+	if _, err := tx.Exec("DELETE FROM user_roles WHERE user_id = $1", userID); err != nil {
+		fmt.Println("Error clearing user roles:", err)
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_roles (user_id, role_id)
+		SELECT $1, id FROM roles WHERE name = ANY($2)
+	`, userID, pq.Array(roles))
+	if err != nil {
+		fmt.Println("Error assigning user roles:", err)
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE users SET is_admin = $1 WHERE id = $2", len(roles) > 0, userID); err != nil {
+		fmt.Println("Error updating admin flag:", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddUserRole grants one more role to the user
+func AddUserRole(userID, role string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		INSERT INTO user_roles (user_id, role_id)
+		SELECT $1, id FROM roles WHERE name = $2
+		ON CONFLICT DO NOTHING
+	`, userID, role)
+	if err != nil {
+		fmt.Println("Error assigning user role:", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)", role).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrUnknownRole
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE users SET is_admin = true WHERE id = $1", userID); err != nil {
+		fmt.Println("Error updating admin flag:", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// RolesHavePermission tells whether one of the roles grants the permission
+func RolesHavePermission(roles []string, permission string) (bool, error) {
+	if len(roles) == 0 {
+		return false, nil
+	}
+
+	var allowed bool
+	err := db.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM roles r JOIN role_permissions rp ON rp.role_id = r.id
+			WHERE r.name = ANY($1) AND rp.permission = $2
+		)
+	`, pq.Array(roles), permission).Scan(&allowed)
+	if err != nil {
+		fmt.Println("Error checking permission:", err)
+		return false, err
+	}
+	return allowed, nil
+}
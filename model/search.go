@@ -0,0 +1,134 @@
+package model
+
+import (
+	"fmt"
+	"html"
+	"maps"
+	"sec-app-server/db"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const MaxSearchLength = 200
+
+// SearchResult is a product matching a search, with the matched words of
+// its name and description wrapped in <mark>, the rest being HTML escaped
+type SearchResult struct {
+	Product
+	Highlight SearchHighlight `json:"highlight"`
+}
+
+type SearchHighlight struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// ts_headline is given control characters as markers, swapped for <mark>
+// once the text is escaped
+const (
+	highlightStart = "\x02"
+	highlightStop  = "\x03"
+)
+
+// SearchCatalog returns a page of the products matching both the text and
+// the filter, by default the most relevant first. The text is matched with
+// French and English stemming and, when pg_trgm is installed, by trigram
+// similarity of its words to tolerate typos.
+func SearchCatalog(text string, filter CatalogFilter) (*SearchPage, error) {
+	q := &productQuery{}
+	q.filter(filter)
+
+	textArg := q.arg(text)
+	tsquery := fmt.Sprintf("(websearch_to_tsquery('french', %[1]s) || websearch_to_tsquery('english', %[1]s))", textArg)
+	match := "p.search_document @@ " + tsquery
+	relevance := fmt.Sprintf("ts_rank_cd(p.search_document, %s)", tsquery)
+	if db.Trigrams {
+		match += fmt.Sprintf(" OR %s <%% p.search_text", textArg)
+		relevance += fmt.Sprintf(" + word_similarity(%s, p.search_text)", textArg)
+	}
+	q.conditions = append(q.conditions, "("+match+")")
+
+	sorts := maps.Clone(catalogSorts)
+	sorts["relevance"] = catalogSort{column: "(" + relevance + ")", descending: true, value: cursorNumber}
+
+	ids, nextCursor, err := pageProductIDs(q, filter, sorts, "relevance", text)
+	if err != nil {
+		return nil, err
+	}
+
+	products, err := loadProducts(ids)
+	if err != nil {
+		return nil, err
+	}
+	highlights, err := searchHighlights(text, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &SearchPage{Results: make([]SearchResult, len(products)), NextCursor: nextCursor}
+	for i, product := range products {
+		page.Results[i] = SearchResult{Product: product, Highlight: highlights[product.ID]}
+	}
+	return page, nil
+}
+
+// searchHighlights marks the matched words with both the French and the
+// English stemming, keeping for each text the headline with the most marks
+func searchHighlights(text string, ids []int) (map[int]SearchHighlight, error) {
+	highlights := map[int]SearchHighlight{}
+	if len(ids) == 0 {
+		return highlights, nil
+	}
+
+	rows, err := db.DB.Query(`
+		WITH q AS (
+			SELECT websearch_to_tsquery('french', $1) || websearch_to_tsquery('english', $1) AS query,
+				'StartSel=' || chr(2) || ', StopSel=' || chr(3) AS markers
+		)
+		SELECT p.id,
+			ts_headline('french', p.name, q.query, q.markers || ', HighlightAll=true'),
+			ts_headline('english', p.name, q.query, q.markers || ', HighlightAll=true'),
+			ts_headline('french', coalesce(p.description, ''), q.query, q.markers || ', MaxFragments=2, MinWords=8, MaxWords=25'),
+			ts_headline('english', coalesce(p.description, ''), q.query, q.markers || ', MaxFragments=2, MinWords=8, MaxWords=25')
+		FROM product p, q
+		WHERE p.id = ANY($2)
+	`, text, pq.Array(ids))
+	if err != nil {
+		fmt.Println("Error highlighting search results:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var frenchName, englishName, frenchDescription, englishDescription string
+		if err := rows.Scan(&id, &frenchName, &englishName, &frenchDescription, &englishDescription); err != nil {
+			return nil, err
+		}
+		highlights[id] = SearchHighlight{
+			Name:        markHighlight(bestHeadline(frenchName, englishName)),
+			Description: markHighlight(bestHeadline(frenchDescription, englishDescription)),
+		}
+	}
+	return highlights, rows.Err()
+}
+
+// bestHeadline returns the headline with the most marked words, the French
+// one on a tie
+func bestHeadline(french, english string) string {
+	if strings.Count(english, highlightStart) > strings.Count(french, highlightStart) {
+		return english
+	}
+	return french
+}
+
+func markHighlight(headline string) string {
+	escaped := html.EscapeString(headline)
+	return strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>").Replace(escaped)
+}
@@ -0,0 +1,33 @@
+package model
+
+import "testing"
+
+func TestBestHeadline(t *testing.T) {
+	mark := func(word string) string { return highlightStart + word + highlightStop }
+
+	tests := []struct {
+		name            string
+		french, english string
+		want            string
+	}{
+		{"english stems only", "Lemon flowers", mark("Lemon") + " " + mark("flowers"), mark("Lemon") + " " + mark("flowers")},
+		{"french stems only", mark("Fleurs") + " de citron", "Fleurs de citron", mark("Fleurs") + " de citron"},
+		{"tie keeps french", mark("Citron") + " flowers", "Citron " + mark("flowers"), mark("Citron") + " flowers"},
+		{"no match", "Citron", "Citron", "Citron"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bestHeadline(tt.french, tt.english); got != tt.want {
+				t.Errorf("bestHeadline = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkHighlight(t *testing.T) {
+	got := markHighlight("<b>" + highlightStart + "Lemon" + highlightStop + " & co")
+	want := "&lt;b&gt;<mark>Lemon</mark> &amp; co"
+	if got != want {
+		t.Errorf("markHighlight = %q, want %q", got, want)
+	}
+}
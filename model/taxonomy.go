@@ -0,0 +1,268 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sec-app-server/db"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrUnknownTaxonomy = errors.New("unknown taxonomy")
+	ErrTermNotFound    = errors.New("taxonomy term not found")
+	ErrTermNameTaken   = errors.New("taxonomy term name already used")
+	ErrMergeIntoSelf   = errors.New("cannot merge a term into itself")
+)
+
+const MaxTermNameLength = 100
+
+// taxonomy is a kind of product term and the table linking it to products
+type taxonomy struct {
+	table  string
+	link   string
+	column string
+}
+
+var (
+	flavorTaxonomy   = taxonomy{"flavor", "has_flavor", "flavor_id"}
+	aspectTaxonomy   = taxonomy{"aspect", "has_aspect", "aspect_id"}
+	effectTaxonomy   = taxonomy{"effet", "has_effect", "effect_id"}
+	idealForTaxonomy = taxonomy{"ideal_for", "is_ideal_for", "ideal_for_id"}
+	categoryTaxonomy = taxonomy{"category", "belongs_to", "category_id"}
+)
+
+// Taxonomies are the names of the taxonomies in the API, as in the catalog
+// filters
+var Taxonomies = []string{"flavor", "aspect", "effect", "ideal_for", "category"}
+
+var taxonomiesByName = map[string]taxonomy{
+	"flavor":    flavorTaxonomy,
+	"aspect":    aspectTaxonomy,
+	"effect":    effectTaxonomy,
+	"effet":     effectTaxonomy, // the name of its table
+	"ideal_for": idealForTaxonomy,
+	"category":  categoryTaxonomy,
+}
+
+func lookupTaxonomy(name string) (taxonomy, error) {
+	t, ok := taxonomiesByName[name]
+	if !ok {
+		return taxonomy{}, ErrUnknownTaxonomy
+	}
+	return t, nil
+}
+
+// TaxonomyTerm is a flavor, aspect, effect, ideal for or category with the
+// number of products it is given to
+type TaxonomyTerm struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	ProductCount int    `json:"product_count"`
+}
+
+// TermError names the field of a taxonomy term that is missing or malformed
+type TermError struct {
+	Field string
+}
+
+func (e *TermError) Error() string {
+	return "invalid taxonomy term field: " + e.Field
+}
+
+// NormalizeTermName trims the name and collapses its inner spaces
+func NormalizeTermName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+func ValidateTermName(name string) error {
+	if name == "" || utf8.RuneCountInString(name) > MaxTermNameLength {
+		return &TermError{Field: "name"}
+	}
+	return nil
+}
+
+// GetTaxonomyTerms returns the terms of the taxonomy by name, each with its
+// product count
+func GetTaxonomyTerms(name string) ([]TaxonomyTerm, error) {
+	t, err := lookupTaxonomy(name)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := []TaxonomyTerm{}
+	rows, err := db.DB.Query(`
+		SELECT t.id, t.name, COUNT(DISTINCT h.product_id)
+		FROM ` + t.table + ` t LEFT JOIN ` + t.link + ` h ON h.` + t.column + ` = t.id
+		GROUP BY t.id, t.name
+		ORDER BY lower(t.name)
+	`)
+	if err != nil {
+		fmt.Println("Error fetching "+t.table+" terms:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var term TaxonomyTerm
+		if err := rows.Scan(&term.ID, &term.Name, &term.ProductCount); err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	return terms, rows.Err()
+}
+
+func GetTaxonomyTerm(name, id string) (*TaxonomyTerm, error) {
+	t, err := lookupTaxonomy(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var term TaxonomyTerm
+	err = db.DB.QueryRow(`
+		SELECT t.id, t.name, (SELECT COUNT(DISTINCT product_id) FROM `+t.link+` WHERE `+t.column+` = t.id)
+		FROM `+t.table+` t
+		WHERE t.id = $1
+	`, id).Scan(&term.ID, &term.Name, &term.ProductCount)
+	if err == sql.ErrNoRows {
+		return nil, ErrTermNotFound
+	}
+	if err != nil {
+		fmt.Println("Error fetching "+t.table+" term:", err)
+		return nil, err
+	}
+	return &term, nil
+}
+
+func AddTaxonomyTerm(name string, term *TaxonomyTerm) error {
+	t, err := lookupTaxonomy(name)
+	if err != nil {
+		return err
+	}
+
+	err = db.DB.QueryRow("INSERT INTO "+t.table+" (name) VALUES ($1) RETURNING id", term.Name).Scan(&term.ID)
+	if isUniqueViolation(err) {
+		return ErrTermNameTaken
+	}
+	if err != nil {
+		fmt.Println("Error adding "+t.table+" term:", err)
+	}
+	return err
+}
+
+func RenameTaxonomyTerm(name string, term *TaxonomyTerm) error {
+	t, err := lookupTaxonomy(name)
+	if err != nil {
+		return err
+	}
+
+	res, err := db.DB.Exec("UPDATE "+t.table+" SET name = $2 WHERE id = $1", term.ID, term.Name)
+	if isUniqueViolation(err) {
+		return ErrTermNameTaken
+	}
+	if err != nil {
+		fmt.Println("Error renaming "+t.table+" term:", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTermNotFound
+	}
+	return nil
+}
+
+// DeleteTaxonomyTerm removes the term from its products, then deletes it
+func DeleteTaxonomyTerm(name, id string) error {
+	t, err := lookupTaxonomy(name)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM "+t.link+" WHERE "+t.column+" = $1", id); err != nil {
+		fmt.Println("Error unlinking "+t.table+" term:", err)
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM "+t.table+" WHERE id = $1", id)
+	if err != nil {
+		fmt.Println("Error deleting "+t.table+" term:", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTermNotFound
+	}
+
+	return tx.Commit()
+}
+
+// MergeTaxonomyTerms gives the products of the source term the target term
+// instead, then deletes the source
+func MergeTaxonomyTerms(name, sourceID, targetID string) error {
+	t, err := lookupTaxonomy(name)
+	if err != nil {
+		return err
+	}
+	if sourceID == targetID {
+		return ErrMergeIntoSelf
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var found int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM (SELECT id FROM "+t.table+" WHERE id IN ($1, $2) FOR UPDATE) t", sourceID, targetID).Scan(&found); err != nil {
+		fmt.Println("Error locking "+t.table+" terms:", err)
+		return err
+	}
+	if found != 2 {
+		return ErrTermNotFound
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO `+t.link+` (product_id, `+t.column+`)
+		SELECT DISTINCT h.product_id, $2::INTEGER FROM `+t.link+` h
+		WHERE h.`+t.column+` = $1
+		AND NOT EXISTS (SELECT 1 FROM `+t.link+` e WHERE e.product_id = h.product_id AND e.`+t.column+` = $2)
+	`, sourceID, targetID)
+	if err != nil {
+		fmt.Println("Error moving "+t.table+" links:", err)
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM "+t.link+" WHERE "+t.column+" = $1", sourceID); err != nil {
+		fmt.Println("Error unlinking "+t.table+" term:", err)
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM "+t.table+" WHERE id = $1", sourceID); err != nil {
+		fmt.Println("Error deleting "+t.table+" term:", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ensureTaxonomyTerm returns the id of the term with that name, whatever its
+// case, creating it if needed
+func ensureTaxonomyTerm(q queryer, t taxonomy, name string) (int, error) {
+	var id int
+	err := q.QueryRow(`
+		WITH created AS (
+			INSERT INTO `+t.table+` (name) VALUES ($1)
+			ON CONFLICT ((lower(name))) DO NOTHING
+			RETURNING id
+		)
+		SELECT id FROM created
+		UNION ALL
+		SELECT id FROM `+t.table+` WHERE lower(name) = lower($1)
+		LIMIT 1
+	`, name).Scan(&id)
+	return id, err
+}
@@ -0,0 +1,137 @@
+package model
+
+import (
+	"fmt"
+	"sec-app-server/db"
+	"sync"
+	"time"
+)
+
+// RevocationStore keeps track of JWTs that must no longer be accepted, either
+// one by one (by jti) or all the tokens of a user at once. Every token carries
+// the generation of its user when it was signed, and revoking all of them
+// moves the user to the next generation. Unlike iat, which only has a
+// precision of a second, a generation tells apart a token signed just before
+// the revocation from one signed just after it.
+type RevocationStore interface {
+	RevokeToken(jti string, expiresAt time.Time) error
+	RevokeAllForUser(email string) error
+	// TokenGeneration is the generation new tokens of the user are signed with
+	TokenGeneration(email string) (int64, error)
+	IsRevoked(jti, email string, generation int64) (bool, error)
+}
+
+// PostgresRevocationStore is the RevocationStore used in production.
+type PostgresRevocationStore struct{}
+
+func (PostgresRevocationStore) RevokeToken(jti string, expiresAt time.Time) error {
+	// the denylist only needs to outlive the token itself
+	_, err := db.DB.Exec("DELETE FROM revoked_tokens WHERE expires_at < NOW()")
+	if err != nil {
+		fmt.Println("Error purging revoked tokens:", err)
+	}
+
+	_, err = db.DB.Exec(`
+		INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING
+	`, jti, expiresAt)
+	if err != nil {
+		fmt.Println("Error revoking token:", err)
+		return err
+	}
+	return nil
+}
+
+func (PostgresRevocationStore) RevokeAllForUser(email string) error {
+	_, err := db.DB.Exec(`
+		INSERT INTO user_token_revocations (user_email, generation) VALUES ($1, 1)
+		ON CONFLICT (user_email) DO UPDATE SET generation = user_token_revocations.generation + 1
+	`, email)
+	if err != nil {
+		fmt.Println("Error revoking user tokens:", err)
+		return err
+	}
+	return nil
+}
+
+func (PostgresRevocationStore) TokenGeneration(email string) (int64, error) {
+	var generation int64
+	err := db.DB.QueryRow(`
+		SELECT COALESCE((SELECT generation FROM user_token_revocations WHERE user_email = $1), 0)
+	`, email).Scan(&generation)
+	if err != nil {
+		fmt.Println("Error getting token generation:", err)
+		return 0, err
+	}
+	return generation, nil
+}
+
+func (PostgresRevocationStore) IsRevoked(jti, email string, generation int64) (bool, error) {
+	var revoked bool
+	err := db.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+			OR EXISTS (SELECT 1 FROM user_token_revocations WHERE user_email = $2 AND generation > $3)
+	`, jti, email, generation).Scan(&revoked)
+	if err != nil {
+		fmt.Println("Error checking token revocation:", err)
+		return false, err
+	}
+	return revoked, nil
+}
+
+// MemoryRevocationStore is an in-process RevocationStore, meant for tests and
+// single instance development servers.
+type MemoryRevocationStore struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+	users  map[string]int64
+}
+
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		tokens: map[string]time.Time{},
+		users:  map[string]int64{},
+	}
+}
+
+func (s *MemoryRevocationStore) RevokeToken(jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, exp := range s.tokens {
+		if exp.Before(now) {
+			delete(s.tokens, k)
+		}
+	}
+	s.tokens[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryRevocationStore) RevokeAllForUser(email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[email]++
+	return nil
+}
+
+func (s *MemoryRevocationStore) TokenGeneration(email string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.users[email], nil
+}
+
+func (s *MemoryRevocationStore) IsRevoked(jti, email string, generation int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tokens[jti]; ok {
+		return true, nil
+	}
+	if generation < s.users[email] {
+		return true, nil
+	}
+	return false, nil
+}
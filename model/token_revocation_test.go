@@ -0,0 +1,75 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRevocationStoreRevokeToken(t *testing.T) {
+	s := NewMemoryRevocationStore()
+
+	if err := s.RevokeToken("revoked", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	for jti, want := range map[string]bool{"revoked": true, "other": false} {
+		revoked, err := s.IsRevoked(jti, "user@example.com", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked != want {
+			t.Errorf("IsRevoked(%q) = %v, want %v", jti, revoked, want)
+		}
+	}
+}
+
+func TestMemoryRevocationStoreRevokeAllForUser(t *testing.T) {
+	s := NewMemoryRevocationStore()
+
+	before, err := s.TokenGeneration("user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RevokeAllForUser("user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	// signed right after the revocation, most likely in the same second
+	after, err := s.TokenGeneration("user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		email      string
+		generation int64
+		want       bool
+	}{
+		{"signed before", "user@example.com", before, true},
+		{"signed after", "user@example.com", after, false},
+		{"other user", "other@example.com", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revoked, err := s.IsRevoked("jti", tt.email, tt.generation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revoked != tt.want {
+				t.Errorf("IsRevoked = %v, want %v", revoked, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryRevocationStoreRevokesAgain(t *testing.T) {
+	s := NewMemoryRevocationStore()
+	s.RevokeAllForUser("user@example.com")
+	generation, _ := s.TokenGeneration("user@example.com")
+	s.RevokeAllForUser("user@example.com")
+
+	revoked, _ := s.IsRevoked("jti", "user@example.com", generation)
+	if !revoked {
+		t.Error("a second revocation must revoke the tokens signed after the first one")
+	}
+}
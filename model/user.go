@@ -1,44 +1,62 @@
 package model
 
 import (
+	sqlpkg "database/sql"
+	"errors"
 	"fmt"
 	"sec-app-server/db"
 	mailcontroller "sec-app-server/mail_controller"
 	"sec-app-server/utils"
+	"time"
 )
 
 type User struct {
 	ID                string    `json:"id"`
 	Username          string `json:"username"`
 	Email             string `json:"email"`
-	Password          string `json:"password"`
+	// Password and VerificationToken are secrets, never serialized
+	Password          string `json:"-"`
 	IsAdmin           bool   `json:"is_admin"`
-	VerificationToken string `json:"verification_token"`
+	VerificationToken string `json:"-"`
 	VerificationDate  string `json:"verification_date"`
 	CreationDate      string `json:"creation_date"`
 }
 
 func RegisterUser(username, email, password string) (*User, error) {
 	var user User
-	token := utils.GenerateRandomString(20) // Generate a random verification token
-	sql, err := db.DB.Prepare("INSERT INTO users (username, email, password, is_admin, verification_token, creation_date) VALUES ($1, $2, $3, $4, $5, $6)")
+	token := utils.GeneratePrefixedID("vrf", utils.DefaultTokenBytes) // Generate a random verification token
+	passwordHash, err := utils.HashPassword(password)
+	if err != nil {
+		fmt.Println("Error hashing password:", err)
+		return nil, err
+	}
+	var contactEmail any
+	if encrypted, err := utils.Encrypt(email); err == nil {
+		contactEmail = encrypted
+	}
+	sql, err := db.DB.Prepare("INSERT INTO users (username, email, password, is_admin, verification_token, verification_expires_at, verification_sent_at, creation_date, email_encrypted) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)")
 	if err != nil {
 		fmt.Println("Error registering user:", err)
 		return nil, err
 	}
-	_, err = sql.Exec(username, utils.HashString(email), utils.HashString(password), false, token, utils.GetCurrentDate())
+	_, err = sql.Exec(username, utils.HashString(email), passwordHash, false, token, time.Now().Add(VerificationTokenLifetime), time.Now(), utils.GetCurrentDate(), contactEmail)
 	if err != nil {
 		fmt.Println("Error executing user registration:", err)
 		return nil, err
 	}
-	err = mailcontroller.SendMail(email, "Account Verification", fmt.Sprintf("Please verify your account by clicking the following link: %s/verify-account/%s", utils.ClientUrl, token))
+	err = sendVerificationMail(email, token)
 
 	if err != nil {
+		// the user can ask for a new one through /user/verify/resend
 		fmt.Println("Error sending verification email:", err)
 	}
 	return &user, nil
 }
 
+func sendVerificationMail(email, token string) error {
+	return mailcontroller.SendMail(email, "Account Verification", fmt.Sprintf("Please verify your account by clicking the following link: %s/verify-account/%s", utils.ClientUrl, token))
+}
+
 func GetUserByEmailOrUsername(emailOrUsername string, alreadyHashed bool) (*User, error) {
 	var username string
 	var email string
@@ -68,32 +86,83 @@ func GetUserByEmailOrUsername(emailOrUsername string, alreadyHashed bool) (*User
 	}, nil
 }
 
-func RemoveUserAdmin(id string) error {
-	sql, err := db.DB.Prepare("DELETE FROM users WHERE id = $1")
+var ErrNoContactEmail = errors.New("no contact email known for this user")
+
+// RememberContactEmail stores the address of the user, encrypted, when it was
+// not known yet. Accounts created before addresses were kept get it back the
+// next time the user types it.
+func RememberContactEmail(userID, email string) error {
+	encrypted, err := utils.Encrypt(email)
 	if err != nil {
-		fmt.Println("Error preparing delete statement:", err)
 		return err
 	}
-	_, err = sql.Exec(id)
+	_, err = db.DB.Exec("UPDATE users SET email_encrypted = $1 WHERE id = $2 AND email = $3 AND email_encrypted IS NULL", encrypted, userID, utils.HashString(email))
 	if err != nil {
-		fmt.Println("Error executing delete statement:", err)
-		return err
+		fmt.Println("Error saving contact email:", err)
 	}
-	return nil
+	return err
+}
+
+// GetContactEmail returns the clear address of the user, to send mails to
+// users only known by their hashed email.
+func GetContactEmail(userID string) (string, error) {
+	var encrypted sqlpkg.NullString
+	err := db.DB.QueryRow("SELECT email_encrypted FROM users WHERE id = $1", userID).Scan(&encrypted)
+	if err != nil {
+		fmt.Println("Error fetching contact email:", err)
+		return "", err
+	}
+	if !encrypted.Valid {
+		return "", ErrNoContactEmail
+	}
+	return utils.Decrypt(encrypted.String)
+}
+
+func GetUserByEmail(email string) (*User, error) {
+	var user User
+	err := db.DB.QueryRow("SELECT id, username, email, is_admin FROM users WHERE email = $1", utils.HashString(email)).Scan(&user.ID, &user.Username, &user.Email, &user.IsAdmin)
+	if err != nil {
+		fmt.Println("Error fetching user by email:", err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+func GetUserByID(id string) (*User, error) {
+	var user User
+	err := db.DB.QueryRow("SELECT id, username, email, is_admin FROM users WHERE id = $1", id).Scan(&user.ID, &user.Username, &user.Email, &user.IsAdmin)
+	if err != nil {
+		fmt.Println("Error fetching user by id:", err)
+		return nil, err
+	}
+	return &user, nil
 }
 
-func RemoveUser(hashEmail string) error {
-	sql, err := db.DB.Prepare("DELETE FROM users WHERE id=$1")
+func RemoveUserAdmin(id string) error {
+	return RemoveUser(id)
+}
+
+// RemoveUser deletes the account with its refresh tokens, which are keyed by
+// email and would otherwise be honoured for an account registered later with
+// the same address. Access tokens are revoked by controller.DeleteUser.
+func RemoveUser(id string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM refresh_tokens WHERE user_email = (SELECT email FROM users WHERE id = $1)", id)
 	if err != nil {
-		fmt.Println("Error preparing delete statement:", err)
+		fmt.Println("Error deleting refresh tokens:", err)
 		return err
 	}
-	_, err = sql.Exec(hashEmail)
+	_, err = tx.Exec("DELETE FROM users WHERE id=$1", id)
 	if err != nil {
 		fmt.Println("Error executing delete statement:", err)
 		return err
 	}
-	return nil
+	return tx.Commit()
 }
 
 func CheckUserExists(username, email string) (bool, bool, error) {
@@ -109,6 +178,12 @@ func CheckUserExists(username, email string) (bool, bool, error) {
 }
 
 func ChangeUserPassword(id, newPassword string) error {
+	passwordHash, err := utils.HashPassword(newPassword)
+	if err != nil {
+		fmt.Println("Error while hashing the password:", err)
+		return err
+	}
+
 	sql, err := db.DB.Prepare("UPDATE users SET password=$1 WHERE id=$2")
 
 	if err != nil {
@@ -116,7 +191,7 @@ func ChangeUserPassword(id, newPassword string) error {
 		return err
 	}
 
-	_, err = sql.Exec(utils.HashString(newPassword), id)
+	_, err = sql.Exec(passwordHash, id)
 
 	if err != nil {
 		fmt.Println("Error while changing the password:", err)
@@ -129,7 +204,7 @@ func ChangeUserPassword(id, newPassword string) error {
 func GetAllUser() ([]User, error) {
 	users := []User{}
 	sql, err := db.DB.Query(`
-		SELECT id, username, email, password, is_admin, COALESCE(verification_token, ''), COALESCE(verification_date, ''), COALESCE(creation_date, '')
+		SELECT id, username, email, is_admin, COALESCE(verification_date, ''), COALESCE(creation_date, '')
 		FROM users
 	`)
 	if err != nil {
@@ -140,7 +215,7 @@ func GetAllUser() ([]User, error) {
 
 	for sql.Next() {
 		var user User
-		if err := sql.Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.IsAdmin, &user.VerificationToken, &user.VerificationDate, &user.CreationDate); err != nil {
+		if err := sql.Scan(&user.ID, &user.Username, &user.Email, &user.IsAdmin, &user.VerificationDate, &user.CreationDate); err != nil {
 			fmt.Println("rrr", err)
 			return nil, err
 		}
@@ -150,41 +225,50 @@ func GetAllUser() ([]User, error) {
 	return users, nil
 }
 
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// AuthenticateUser checks the password of the user, and upgrades its stored
+// hash when it was made with a legacy algorithm.
 func AuthenticateUser(email, password string) (*User, error) {
 	var user User
-	err := db.DB.QueryRow("SELECT id, username, email, password, is_admin FROM users WHERE (email = $1 OR username=$2) AND password = $3", utils.HashString(email), email, utils.HashString(password)).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.IsAdmin)
+	err := db.DB.QueryRow("SELECT id, username, email, password, is_admin FROM users WHERE (email = $1 OR username=$2)", utils.HashString(email), email).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.IsAdmin)
 	if err != nil {
 		fmt.Println("Error authenticating user:", err)
-		return nil, err
+		utils.BurnPasswordCheck(password)
+		return nil, ErrInvalidCredentials
 	}
+
+	ok, needsRehash := utils.VerifyPassword(user.Password, password)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	if needsRehash {
+		if err := ChangeUserPassword(user.ID, password); err != nil {
+			fmt.Println("Error upgrading password hash:", err)
+		}
+	}
+
+	user.Password = ""
 	return &user, nil
 }
 
-func IsPasswordCorrect(hashEmail, hashPassword string) bool {
-	var res bool
-	err := db.DB.QueryRow("SELECT EXISTS (SELECT * FROM users WHERE email=$1 AND password=$2)", hashEmail, hashPassword).Scan(&res)
+func IsPasswordCorrect(hashEmail, password string) bool {
+	var passwordHash string
+	err := db.DB.QueryRow("SELECT password FROM users WHERE email=$1", hashEmail).Scan(&passwordHash)
 	if err != nil {
 		fmt.Println("Error verifying password")
+		utils.BurnPasswordCheck(password)
 		return false
 	}
-	return res
+	ok, _ := utils.VerifyPassword(passwordHash, password)
+	return ok
 }
 
 // func AddToFav(userID, )
 
 func MakeUserAdmin(id string) error {
-	sql, err := db.DB.Prepare("UPDATE users SET is_admin = true WHERE id = $1")
-	if err != nil {
-		fmt.Println("Error preparing verification statement:", err)
-		return err
-	}
-
-	_, err = sql.Exec(id)
-	if err != nil {
-		fmt.Println("Error executing update statement:", err)
-		return err
-	}
-	return nil
+	return AddUserRole(id, RoleSuperAdmin)
 }
 
 
@@ -218,13 +302,31 @@ func IsUserVerified(email string) bool {
 	return isUserVerified
 }
 
+const (
+	VerificationTokenLifetime = 24 * time.Hour
+	// minimum delay between two verification mails sent to the same address
+	VerificationResendInterval = 2 * time.Minute
+)
+
+var (
+	ErrVerificationTokenInvalid = errors.New("verification token is invalid")
+	ErrVerificationTokenExpired = errors.New("verification token has expired")
+)
+
 func VerifyUser(token string) error {
 	var userID int
-	err := db.DB.QueryRow("SELECT id FROM users WHERE verification_token = $1", token).Scan(&userID)
+	var expiresAt time.Time
+	err := db.DB.QueryRow("SELECT id, verification_expires_at FROM users WHERE verification_token = $1", token).Scan(&userID, &expiresAt)
+	if err == sqlpkg.ErrNoRows {
+		return ErrVerificationTokenInvalid
+	}
 	if err != nil {
 		fmt.Println("Error verifying user:", err)
 		return err
 	}
+	if time.Now().After(expiresAt) {
+		return ErrVerificationTokenExpired
+	}
 
 	sql, err := db.DB.Prepare("UPDATE users SET verification_token = NULL, verification_date = $1 WHERE id = $2")
 	if err != nil {
@@ -239,94 +341,33 @@ func VerifyUser(token string) error {
 	return nil
 }
 
-func AddProductToCart(userID, productID string, quantity int) error {
-	sql, err := db.DB.Prepare("INSERT INTO cart (user_id, product_id, quantity) VALUES ($1, $2, $3)")
-	if err != nil {
-		fmt.Println("Error preparing add to cart statement:", err)
-		return err
-	}
-	_, err = sql.Exec(userID, productID, quantity)
-	if err != nil {
-		fmt.Println("Error executing add to cart statement:", err)
-		return err
-	}
-	return nil
-}
-
-func OrderCart(userID string) error {
-	// get all products in the cart with the quantity
-	products := []struct {
-		product  *Product
-		quantity int
-	}{}
-
-	sql, err := db.DB.Query("SELECT product_id, quantity FROM cart WHERE user_id = $1", userID)
-	if err != nil {
-		fmt.Println("Error fetching cart items:", err)
-		return err
-	}
-	defer sql.Close()
-	for sql.Next() {
-		var productID string
-		var quantity int
-		if err := sql.Scan(&productID, &quantity); err != nil {
-			fmt.Println("Error scanning cart item:", err)
-			return err
-		}
-
-		product, err := GetProductByID(productID)
+// ResendVerification issues a fresh verification token to the unverified
+// account of the address and mails it, unless a mail was sent to it less
+// than VerificationResendInterval ago. Nothing tells the caller whether
+// such an account exists: the token and the mail are handled in the
+// background.
+func ResendVerification(email string) {
+	go func() {
+		token := utils.GeneratePrefixedID("vrf", utils.DefaultTokenBytes)
+		now := time.Now()
+		res, err := db.DB.Exec(`
+			UPDATE users SET verification_token = $1, verification_expires_at = $2, verification_sent_at = $3
+			WHERE email = $4 AND verification_date IS NULL
+				AND (verification_sent_at IS NULL OR verification_sent_at < $5)
+		`, token, now.Add(VerificationTokenLifetime), now, utils.HashString(email), now.Add(-VerificationResendInterval))
 		if err != nil {
-			fmt.Println("Error fetching product:", err)
-			return err
+			fmt.Println("Error renewing verification token:", err)
+			return
 		}
 
-		products = append(products, struct {
-			product  *Product
-			quantity int
-		}{product: product, quantity: quantity})
-	}
-
-	// create a new order
-	orderID := utils.GenerateRandomString(10) // Generate a random order ID
-	sqlOrder, err := db.DB.Prepare("INSERT INTO orders (numero, price, date, status) VALUES ($1, $2, $3, $4)")
-	if err != nil {
-		fmt.Println("Error preparing order statement:", err)
-		return err
-	}
-
-	// Calculate total price
-	totalPrice := 0.0
-	for _, item := range products {
-		totalPrice += item.product.Price * float64(item.quantity)
-	}
-	_, err = sqlOrder.Exec(orderID, fmt.Sprintf("%.2f", totalPrice), utils.GetCurrentDate(), "pending")
-	if err != nil {
-		fmt.Println("Error executing order statement:", err)
-		return err
-	}
-	// Add products to has_ordered table
-	for _, item := range products {
-		sqlHasOrdered, err := db.DB.Prepare("INSERT INTO has_ordered (user_id, order_id, quantity) VALUES ($1, $2, $3)")
-		if err != nil {
-			fmt.Println("Error preparing has_ordered statement:", err)
-			return err
+		if n, _ := res.RowsAffected(); n == 0 {
+			// unknown or already verified address, or a mail sent too
+			// recently: nothing to send
+			return
 		}
-		_, err = sqlHasOrdered.Exec(userID, orderID, item.quantity)
-		if err != nil {
-			fmt.Println("Error executing has_ordered statement:", err)
-			return err
+
+		if err := sendVerificationMail(email, token); err != nil {
+			fmt.Println("Error sending verification email:", err)
 		}
-	}
-	// Clear the cart after ordering
-	sqlClearCart, err := db.DB.Prepare("DELETE FROM cart WHERE user_id = $1")
-	if err != nil {
-		fmt.Println("Error preparing clear cart statement:", err)
-		return err
-	}
-	_, err = sqlClearCart.Exec(userID)
-	if err != nil {
-		fmt.Println("Error executing clear cart statement:", err)
-		return err
-	}
-	return nil
+	}()
 }
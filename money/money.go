@@ -0,0 +1,276 @@
+// Package money handles amounts exactly, as integers of the minor unit of
+// their currency (cents for euros), instead of float64.
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency of the shop, used for the amounts read
+// from the database and the API which do not name theirs
+var DefaultCurrency = "EUR"
+
+// minorUnits is the number of decimals of the currencies that do not have
+// the usual 2
+var minorUnits = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"BHD": 3,
+	"KWD": 3,
+	"TND": 3,
+}
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+func SetDefaultCurrency(code string) error {
+	code = strings.ToUpper(code)
+	if !currencyCode.MatchString(code) {
+		return fmt.Errorf("invalid currency code %q", code)
+	}
+	DefaultCurrency = code
+	return nil
+}
+
+// Money is an amount of Amount minor units of Currency. The zero value is
+// nothing in the default currency.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// New returns an amount given in minor units of the default currency
+func New(minor int64) Money {
+	return Money{Amount: minor, Currency: DefaultCurrency}
+}
+
+// FromFloat converts a float amount in major units, rounding half away from
+// zero. It is only meant for values that were stored as floats.
+func FromFloat(amount float64) Money {
+	scale := math.Pow10(exponent(DefaultCurrency))
+	return New(int64(math.Round(amount * scale)))
+}
+
+// Parse reads a decimal amount in major units of the default currency, such
+// as "12.5" or "-3.99". Digits past the minor unit are rounded half away from
+// zero.
+func Parse(s string) (Money, error) {
+	return ParseIn(s, DefaultCurrency)
+}
+
+// ParseIn is Parse for an amount of the given currency
+func ParseIn(s, currency string) (Money, error) {
+	s = strings.TrimSpace(s)
+	digits := s
+	negative := false
+	if len(digits) > 0 && (digits[0] == '-' || digits[0] == '+') {
+		negative = digits[0] == '-'
+		digits = digits[1:]
+	}
+
+	whole, fraction, _ := strings.Cut(digits, ".")
+	if whole == "" {
+		whole = "0"
+	}
+	if !isDigits(whole) || !isDigits(fraction) || digits == "" || digits == "." {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+
+	exp := exponent(currency)
+	roundUp := false
+	if len(fraction) > exp {
+		roundUp = fraction[exp] >= '5'
+		fraction = fraction[:exp]
+	}
+	fraction += strings.Repeat("0", exp-len(fraction))
+
+	minor, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q: %w", s, err)
+	}
+	if roundUp {
+		if minor == math.MaxInt64 {
+			return Money{}, fmt.Errorf("invalid amount %q: out of range", s)
+		}
+		minor++
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{Amount: minor, Currency: currency}, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func exponent(currency string) int {
+	if exp, ok := minorUnits[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+func (m Money) currency() string {
+	if m.Currency == "" {
+		return DefaultCurrency
+	}
+	return m.Currency
+}
+
+// String formats the amount in major units, "12.50" for 1250 cents
+func (m Money) String() string {
+	exp := exponent(m.currency())
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if exp == 0 {
+		return sign + strconv.FormatInt(amount, 10)
+	}
+	scale := int64(math.Pow10(exp))
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/scale, exp, amount%scale)
+}
+
+func (m Money) mustMatch(other Money) string {
+	if m.currency() != other.currency() {
+		panic(fmt.Sprintf("money: mixing %s and %s", m.currency(), other.currency()))
+	}
+	return m.currency()
+}
+
+func (m Money) Add(other Money) Money {
+	return Money{Amount: m.Amount + other.Amount, Currency: m.mustMatch(other)}
+}
+
+func (m Money) Sub(other Money) Money {
+	return Money{Amount: m.Amount - other.Amount, Currency: m.mustMatch(other)}
+}
+
+// Mul returns the amount times n, for a quantity. Like mixing currencies, an
+// overflow is a bug and panics.
+func (m Money) Mul(n int) Money {
+	return Money{Amount: mulChecked(m.Amount, int64(n)), Currency: m.currency()}
+}
+
+// Percent returns basisPoints hundredths of a percent of the amount (1250
+// for 12.5%), rounded half away from zero to the minor unit
+func (m Money) Percent(basisPoints int64) Money {
+	product := mulChecked(m.Amount, basisPoints)
+	if abs(product) > math.MaxInt64-5000 {
+		panic(fmt.Sprintf("money: %d basis points of %d overflows", basisPoints, m.Amount))
+	}
+	rounded := (abs(product) + 5000) / 10000
+	if product < 0 {
+		rounded = -rounded
+	}
+	return Money{Amount: rounded, Currency: m.currency()}
+}
+
+func mulChecked(a, b int64) int64 {
+	product := a * b
+	if a != 0 && (product/a != b || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64)) {
+		panic(fmt.Sprintf("money: %d * %d overflows", a, b))
+	}
+	return product
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func (m Money) Min(other Money) Money {
+	m.mustMatch(other)
+	if other.Amount < m.Amount {
+		return other
+	}
+	return m
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) LessThan(other Money) bool {
+	m.mustMatch(other)
+	return m.Amount < other.Amount
+}
+
+// MarshalJSON writes the amount as a number in major units, as prices always
+// were in the API
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON reads a number or a string in major units of the default
+// currency
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var raw json.Number
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	parsed, err := Parse(raw.String())
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan reads a NUMERIC, TEXT or float column holding major units
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	case float64:
+		*m = FromFloat(v)
+	case int64:
+		*m = New(v * int64(math.Pow10(exponent(DefaultCurrency))))
+	case nil:
+		*m = New(0)
+	default:
+		return fmt.Errorf("money: cannot scan %T", src)
+	}
+	return nil
+}
+
+func (m *Money) scanString(s string) error {
+	// float columns may come back in exponent notation
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		*m = FromFloat(f)
+		return nil
+	}
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Value stores the amount as a decimal string in major units, which NUMERIC,
+// TEXT and float columns all accept
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
@@ -0,0 +1,169 @@
+package money
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"12.5", 1250},
+		{"12.50", 1250},
+		{"-3.99", -399},
+		{"+3.99", 399},
+		{" 7 ", 700},
+		{".5", 50},
+		{"5.", 500},
+		{"0", 0},
+		{"1.005", 101},
+		{"1.004", 100},
+		{"-1.005", -101},
+		{"92233720368547758.07", math.MaxInt64},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.in, err)
+			continue
+		}
+		if got != New(tt.want) {
+			t.Errorf("Parse(%q) = %v, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, in := range []string{"", ".", "-", "+", "-+5", "+-5", "--5", "1.2.3", "1,5", "abc", "1e3", "92233720368547758.08", "92233720368547758.075"} {
+		if got, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{New(1250), "12.50"},
+		{New(5), "0.05"},
+		{New(-399), "-3.99"},
+		{New(-5), "-0.05"},
+		{Money{}, "0.00"},
+		{Money{Amount: 1500, Currency: "JPY"}, "1500"},
+		{Money{Amount: 1500, Currency: "TND"}, "1.500"},
+	}
+	for _, tt := range tests {
+		if got := tt.money.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.money, got, tt.want)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, amount := range []int64{0, 1, 1250, -399, 100000} {
+		data, err := json.Marshal(New(amount))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Money
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", data, err)
+		}
+		if got != New(amount) {
+			t.Errorf("round trip of %d through %s = %v", amount, data, got)
+		}
+	}
+
+	var fromString Money
+	if err := json.Unmarshal([]byte(`"12.5"`), &fromString); err != nil || fromString != New(1250) {
+		t.Errorf(`Unmarshal("12.5") = %v, %v`, fromString, err)
+	}
+	var invalid Money
+	if err := json.Unmarshal([]byte(`"-+5"`), &invalid); err == nil {
+		t.Error(`Unmarshal("-+5") did not fail`)
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		amount      int64
+		basisPoints int64
+		want        int64
+	}{
+		{1000, 1000, 100},
+		{1999, 1250, 250}, // 249.875
+		{1, 5000, 1},      // 0.5 rounds away from zero
+		{1, 4999, 0},
+		{-1, 5000, -1},
+		{-1999, 1250, -250},
+		{1234, 0, 0},
+		{1234, 10000, 1234},
+	}
+	for _, tt := range tests {
+		if got := New(tt.amount).Percent(tt.basisPoints); got != New(tt.want) {
+			t.Errorf("%d.Percent(%d) = %d, want %d", tt.amount, tt.basisPoints, got.Amount, tt.want)
+		}
+	}
+}
+
+func TestMin(t *testing.T) {
+	tests := []struct {
+		a, b, want int64
+	}{
+		{100, 200, 100},
+		{200, 100, 100},
+		{-5, 5, -5},
+		{7, 7, 7},
+	}
+	for _, tt := range tests {
+		if got := New(tt.a).Min(New(tt.b)); got != New(tt.want) {
+			t.Errorf("Min(%d, %d) = %d, want %d", tt.a, tt.b, got.Amount, tt.want)
+		}
+	}
+}
+
+func TestOverflowPanics(t *testing.T) {
+	tests := map[string]func(){
+		"Mul":              func() { New(math.MaxInt64 / 2).Mul(3) },
+		"Mul of MinInt64":  func() { New(math.MinInt64).Mul(-1) },
+		"Percent":          func() { New(math.MaxInt64 / 100).Percent(10000) },
+		"mixed currencies": func() { New(1).Add(Money{Amount: 1, Currency: "USD"}) },
+	}
+	for name, fn := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("did not panic")
+				}
+			}()
+			fn()
+		})
+	}
+}
+
+func TestParseIn(t *testing.T) {
+	tests := []struct {
+		in, currency string
+		want         int64
+	}{
+		{"1500", "JPY", 1500},
+		{"1500.5", "JPY", 1501},
+		{"1.500", "TND", 1500},
+		{"12.500", "EUR", 1250},
+	}
+	for _, tt := range tests {
+		got, err := ParseIn(tt.in, tt.currency)
+		if err != nil {
+			t.Errorf("ParseIn(%q, %s): %v", tt.in, tt.currency, err)
+			continue
+		}
+		if want := (Money{Amount: tt.want, Currency: tt.currency}); got != want {
+			t.Errorf("ParseIn(%q, %s) = %#v, want %#v", tt.in, tt.currency, got, want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sec-app-server/money"
+	"sec-app-server/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeSignatureHeader carries the signature of fake webhooks, as
+// "t=<unix time>,v1=<hex HMAC-SHA256 of "<t>.<payload>">"
+const FakeSignatureHeader = "Fake-Signature"
+
+// webhookTolerance is how old a signed webhook may be, against replays
+const webhookTolerance = 5 * time.Minute
+
+type fakeIntent struct {
+	Intent
+	captured bool
+	refunded money.Money
+}
+
+// FakeProvider is a provider that works offline, for development and tests.
+// Payments are made by sending it webhooks signed with SignEvent.
+type FakeProvider struct {
+	secret []byte
+
+	mu      sync.Mutex
+	intents map[string]*fakeIntent
+	// intent and refund ids by idempotency key
+	intentKeys map[string]string
+	refunds    map[string]string
+}
+
+func NewFakeProvider(webhookSecret []byte) *FakeProvider {
+	return &FakeProvider{secret: webhookSecret, intents: map[string]*fakeIntent{}, intentKeys: map[string]string{}, refunds: map[string]string{}}
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeProvider) CreateIntent(ctx context.Context, reference string, amount money.Money, idempotencyKey string) (*Intent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if id, ok := p.intentKeys[idempotencyKey]; ok {
+		return &p.intents[id].Intent, nil
+	}
+
+	intent := &fakeIntent{Intent: Intent{
+		ID:           utils.GeneratePrefixedID("pi", 12),
+		ClientSecret: utils.GenerateToken(24),
+		Amount:       amount,
+		Currency:     amount.Currency,
+	}}
+	p.intents[intent.ID] = intent
+	p.intentKeys[idempotencyKey] = intent.ID
+	return &intent.Intent, nil
+}
+
+func (p *FakeProvider) Capture(ctx context.Context, intentID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[intentID]
+	if !ok {
+		return ErrUnknownIntent
+	}
+	intent.captured = true
+	return nil
+}
+
+// Refund of the fake provider does not know about intents from before a
+// restart, and accepts them
+func (p *FakeProvider) Refund(ctx context.Context, intentID string, amount money.Money, idempotencyKey string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if refundID, ok := p.refunds[idempotencyKey]; ok {
+		return refundID, nil
+	}
+	if intent, ok := p.intents[intentID]; ok {
+		refunded := intent.refunded.Add(amount)
+		if intent.Amount.LessThan(refunded) {
+			return "", fmt.Errorf("refund of %s exceeds the payment of %s", refunded, intent.Amount)
+		}
+		intent.refunded = refunded
+	}
+	refundID := utils.GeneratePrefixedID("re", 12)
+	p.refunds[idempotencyKey] = refundID
+	return refundID, nil
+}
+
+func (p *FakeProvider) VerifyWebhook(payload []byte, header http.Header) (*Event, error) {
+	var timestamp, signature string
+	for _, part := range strings.Split(header.Get(FakeSignatureHeader), ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > webhookTolerance || age < -webhookTolerance {
+		return nil, ErrInvalidSignature
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, p.sign(timestamp, payload)) {
+		return nil, ErrInvalidSignature
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	if event.ID == "" || event.IntentID == "" {
+		return nil, fmt.Errorf("incomplete webhook event")
+	}
+	return &event, nil
+}
+
+// SignEvent returns the body and headers of a webhook reporting the event, as
+// the real provider would send it
+func (p *FakeProvider) SignEvent(event Event) ([]byte, http.Header, error) {
+	if event.ID == "" {
+		event.ID = utils.GeneratePrefixedID("evt", 12)
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	header := http.Header{}
+	header.Set(FakeSignatureHeader, fmt.Sprintf("t=%s,v1=%s", timestamp, hex.EncodeToString(p.sign(timestamp, payload))))
+	return payload, header, nil
+}
+
+func (p *FakeProvider) sign(timestamp string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
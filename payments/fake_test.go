@@ -0,0 +1,60 @@
+package payments
+
+import (
+	"context"
+	"sec-app-server/money"
+	"testing"
+)
+
+func TestFakeRefundIdempotency(t *testing.T) {
+	ctx := context.Background()
+	p := NewFakeProvider([]byte("secret"))
+	amount := money.New(1000)
+
+	intent, err := p.CreateIntent(ctx, "CMD-1", amount, "intent:order:1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := p.Refund(ctx, intent.ID, amount, "refund:order:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := p.Refund(ctx, intent.ID, amount, "refund:order:1")
+	if err != nil {
+		t.Fatalf("retried refund: %v", err)
+	}
+	if again != first {
+		t.Errorf("retried refund = %q, want %q", again, first)
+	}
+
+	if _, err := p.Refund(ctx, intent.ID, amount, "refund:order:2"); err == nil {
+		t.Error("second refund with another key exceeded the payment without error")
+	}
+}
+
+func TestFakeCreateIntentIdempotency(t *testing.T) {
+	ctx := context.Background()
+	p := NewFakeProvider([]byte("secret"))
+	amount := money.New(1000)
+
+	first, err := p.CreateIntent(ctx, "CMD-1", amount, "intent:order:1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := p.CreateIntent(ctx, "CMD-1", amount, "intent:order:1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != first.ID {
+		t.Errorf("retried intent = %q, want %q", again.ID, first.ID)
+	}
+
+	retry, err := p.CreateIntent(ctx, "CMD-1", amount, "intent:order:1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.ID == first.ID {
+		t.Error("another key returned the same intent")
+	}
+}
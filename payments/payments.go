@@ -0,0 +1,72 @@
+// Package payments talks to the payment provider. The rest of the server only
+// sees the Provider interface, the provider in use being picked at startup.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sec-app-server/money"
+)
+
+// Event types reported by webhooks, whatever the provider
+const (
+	EventPaymentSucceeded = "payment.succeeded"
+	EventPaymentFailed    = "payment.failed"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrUnknownIntent    = errors.New("unknown payment intent")
+)
+
+// Intent is a payment the customer is asked to make for an order
+type Intent struct {
+	ID string `json:"intent_id"`
+	// ClientSecret lets the client confirm the payment with the provider
+	ClientSecret string      `json:"client_secret"`
+	Amount       money.Money `json:"amount"`
+	Currency     string      `json:"currency"`
+}
+
+// Event is a verified webhook notification
+type Event struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	IntentID string `json:"intent_id"`
+}
+
+type Provider interface {
+	Name() string
+	// CreateIntent starts the payment of an order, reference being shown to
+	// the customer by the provider. Calls with the same idempotency key
+	// return the same intent.
+	CreateIntent(ctx context.Context, reference string, amount money.Money, idempotencyKey string) (*Intent, error)
+	Capture(ctx context.Context, intentID string) error
+	// Refund gives back amount of a captured payment, returning the id of the
+	// refund. Calls with the same idempotency key make a single refund.
+	Refund(ctx context.Context, intentID string, amount money.Money, idempotencyKey string) (string, error)
+	// VerifyWebhook checks the signature of a webhook request and decodes it
+	VerifyWebhook(payload []byte, header http.Header) (*Event, error)
+}
+
+// Current is the provider orders are paid with, set by Load
+var Current Provider
+
+// Load picks the provider from PAYMENT_PROVIDER, "fake" by default, the only
+// one for now
+func Load() error {
+	switch name := os.Getenv("PAYMENT_PROVIDER"); name {
+	case "", "fake":
+		secret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+		if secret == "" {
+			return fmt.Errorf("PAYMENT_WEBHOOK_SECRET is required")
+		}
+		Current = NewFakeProvider([]byte(secret))
+	default:
+		return fmt.Errorf("unknown payment provider %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var ErrNoEncryptionKey = errors.New("no data encryption key configured")
+
+var dataCipher cipher.AEAD
+
+// LoadEncryptionKey sets up the AES-256-GCM key, given base64 encoded, used
+// for the few personal data the server needs to read back (contact emails).
+func LoadEncryptionKey(encodedKey string) error {
+	if encodedKey == "" {
+		return ErrNoEncryptionKey
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return err
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("data encryption key must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	dataCipher, err = cipher.NewGCM(block)
+	return err
+}
+
+func Encrypt(plaintext string) (string, error) {
+	if dataCipher == nil {
+		return "", ErrNoEncryptionKey
+	}
+
+	nonce := RandomBytes(dataCipher.NonceSize())
+	sealed := dataCipher.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func Decrypt(encoded string) (string, error) {
+	if dataCipher == nil {
+		return "", ErrNoEncryptionKey
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < dataCipher.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := sealed[:dataCipher.NonceSize()], sealed[dataCipher.NonceSize():]
+	plaintext, err := dataCipher.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestEncryptRoundTrip(t *testing.T) {
+	if err := LoadEncryptionKey(base64.StdEncoding.EncodeToString(RandomBytes(32))); err != nil {
+		t.Fatal(err)
+	}
+
+	secret := GenerateTOTPSecret()
+	encrypted, err := Encrypt(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted == secret {
+		t.Fatal("Encrypt returned the plaintext")
+	}
+	if again, _ := Encrypt(secret); again == encrypted {
+		t.Error("two encryptions share a nonce")
+	}
+
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != secret {
+		t.Errorf("Decrypt = %q, want %q", decrypted, secret)
+	}
+
+	sealed, _ := base64.StdEncoding.DecodeString(encrypted)
+	sealed[len(sealed)-1] ^= 1
+	if _, err := Decrypt(base64.StdEncoding.EncodeToString(sealed)); err == nil {
+		t.Error("Decrypt accepted a tampered ciphertext")
+	}
+}
+
+func TestLoadEncryptionKeyRejects(t *testing.T) {
+	for _, key := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(RandomBytes(16))} {
+		if err := LoadEncryptionKey(key); err == nil {
+			t.Errorf("LoadEncryptionKey(%q) accepted", key)
+		}
+	}
+}
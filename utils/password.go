@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Current argon2id parameters (OWASP baseline). Hashes made with other
+// parameters still verify, and are reported as needing a rehash.
+const (
+	argonMemory  uint32 = 19 * 1024
+	argonTime    uint32 = 2
+	argonThreads uint8  = 1
+	argonKeyLen  uint32 = 32
+	argonSaltLen        = 16
+)
+
+// dummyPasswordHash is verified against when the account does not exist, so
+// that the response time does not tell whether it does.
+var dummyPasswordHash, _ = HashPassword("dummy-password")
+
+// HashPassword returns the encoded argon2id hash of the password, in the
+// usual "$argon2id$v=19$m=...,t=...,p=...$salt$hash" format.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	), nil
+}
+
+// VerifyPassword checks the password against an encoded hash in constant
+// time. needsRehash is true when the hash matched but was made with a legacy
+// algorithm or older parameters.
+func VerifyPassword(encoded, password string) (ok bool, needsRehash bool) {
+	if !strings.HasPrefix(encoded, "$") {
+		// legacy unsalted sha256 hex digest
+		ok = subtle.ConstantTimeCompare([]byte(HashString(password)), []byte(encoded)) == 1
+		return ok, ok
+	}
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(expected)))
+	ok = subtle.ConstantTimeCompare(hash, expected) == 1
+
+	needsRehash = memory != argonMemory || time != argonTime || threads != argonThreads || uint32(len(expected)) != argonKeyLen
+	return ok, ok && needsRehash
+}
+
+// BurnPasswordCheck spends the same time as a real verification, to be
+// called when there is no stored hash to verify against.
+func BurnPasswordCheck(password string) {
+	VerifyPassword(dummyPasswordHash, password)
+}
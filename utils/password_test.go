@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestHashAndVerifyPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, needsRehash := VerifyPassword(hash, "correct horse"); !ok || needsRehash {
+		t.Errorf("VerifyPassword(right password) = %v, %v, want true, false", ok, needsRehash)
+	}
+	if ok, _ := VerifyPassword(hash, "wrong horse"); ok {
+		t.Error("VerifyPassword accepted a wrong password")
+	}
+
+	other, _ := HashPassword("correct horse")
+	if other == hash {
+		t.Error("two hashes of the same password share their salt")
+	}
+}
+
+func TestVerifyLegacyPassword(t *testing.T) {
+	legacy := HashString("correct horse")
+
+	if ok, needsRehash := VerifyPassword(legacy, "correct horse"); !ok || !needsRehash {
+		t.Errorf("VerifyPassword(legacy, right password) = %v, %v, want true, true", ok, needsRehash)
+	}
+	if ok, needsRehash := VerifyPassword(legacy, "wrong horse"); ok || needsRehash {
+		t.Errorf("VerifyPassword(legacy, wrong password) = %v, %v, want false, false", ok, needsRehash)
+	}
+}
+
+func TestVerifyPasswordOlderParameters(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte("correct horse"), salt, 1, 8*1024, 1, 32)
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, 8*1024, 1, 1,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+	if ok, needsRehash := VerifyPassword(encoded, "correct horse"); !ok || !needsRehash {
+		t.Errorf("VerifyPassword(older parameters) = %v, %v, want true, true", ok, needsRehash)
+	}
+	if ok, needsRehash := VerifyPassword(encoded, "wrong horse"); ok || needsRehash {
+		t.Errorf("VerifyPassword(older parameters, wrong password) = %v, %v, want false, false", ok, needsRehash)
+	}
+}
+
+func TestVerifyPasswordMalformed(t *testing.T) {
+	for _, encoded := range []string{"", "$argon2id$v=19$m=1", "$bcrypt$v=19$m=19456,t=2,p=1$c2FsdA$aGFzaA", "$argon2id$v=18$m=19456,t=2,p=1$c2FsdA$aGFzaA"} {
+		if ok, _ := VerifyPassword(encoded, "password"); ok {
+			t.Errorf("VerifyPassword(%q) = true", encoded)
+		}
+	}
+}
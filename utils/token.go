@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// TokenEncoding is the textual form of a random token. All of them are safe
+// to put in an URL without escaping.
+type TokenEncoding int
+
+const (
+	// Base64URL is the most compact form, case sensitive
+	Base64URL TokenEncoding = iota
+	// Base32 is lowercase only, easier to read out or type
+	Base32
+	Hex
+)
+
+// DefaultTokenBytes is the entropy used for secrets such as session or reset
+// tokens: 256 bits.
+const DefaultTokenBytes = 32
+
+var lowerBase32 = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// RandomBytes returns n bytes from the system CSPRNG
+func RandomBytes(n int) []byte {
+	b := make([]byte, n)
+	// crypto/rand.Read never fails since go 1.24, it crashes the program instead
+	rand.Read(b)
+	return b
+}
+
+// GenerateToken returns a random URL-safe token carrying the given number of
+// bytes of entropy.
+func GenerateToken(entropyBytes int) string {
+	return GenerateTokenWithEncoding(entropyBytes, Base64URL)
+}
+
+func GenerateTokenWithEncoding(entropyBytes int, encoding TokenEncoding) string {
+	b := RandomBytes(entropyBytes)
+	switch encoding {
+	case Base32:
+		return lowerBase32.EncodeToString(b)
+	case Hex:
+		return hex.EncodeToString(b)
+	default:
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+}
+
+// GeneratePrefixedID returns an identifier such as "ord_k5vq3e7m2xjq4a", the
+// prefix telling at a glance what kind of object it refers to.
+func GeneratePrefixedID(prefix string, entropyBytes int) string {
+	return strings.TrimSuffix(prefix, "_") + "_" + GenerateTokenWithEncoding(entropyBytes, Base32)
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGenerateTokenWithEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding TokenEncoding
+		length   int
+		alphabet *regexp.Regexp
+	}{
+		// 32 bytes: 43 characters of 6 bits, 52 of 5 bits, 64 of 4 bits
+		{"base64url", Base64URL, 43, regexp.MustCompile(`^[A-Za-z0-9_-]+$`)},
+		{"base32", Base32, 52, regexp.MustCompile(`^[a-z2-7]+$`)},
+		{"hex", Hex, 64, regexp.MustCompile(`^[0-9a-f]+$`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := map[string]bool{}
+			for i := 0; i < 1000; i++ {
+				token := GenerateTokenWithEncoding(DefaultTokenBytes, tt.encoding)
+				if len(token) != tt.length {
+					t.Fatalf("len(%q) = %d, want %d", token, len(token), tt.length)
+				}
+				if !tt.alphabet.MatchString(token) {
+					t.Fatalf("%q has characters outside of %s", token, tt.alphabet)
+				}
+				if seen[token] {
+					t.Fatalf("%q generated twice", token)
+				}
+				seen[token] = true
+			}
+		})
+	}
+}
+
+func TestGenerateToken(t *testing.T) {
+	for _, n := range []int{1, 16, 32} {
+		token := GenerateToken(n)
+		if want := (n*8 + 5) / 6; len(token) != want {
+			t.Errorf("len(GenerateToken(%d)) = %d, want %d", n, len(token), want)
+		}
+		if !regexp.MustCompile(`^[A-Za-z0-9_-]+$`).MatchString(token) {
+			t.Errorf("GenerateToken(%d) = %q, not base64url", n, token)
+		}
+	}
+}
+
+func TestGeneratePrefixedID(t *testing.T) {
+	format := regexp.MustCompile(`^ord_[a-z2-7]{16}$`)
+
+	seen := map[string]bool{}
+	for _, prefix := range []string{"ord", "ord_"} {
+		for i := 0; i < 1000; i++ {
+			id := GeneratePrefixedID(prefix, 10)
+			if !format.MatchString(id) {
+				t.Fatalf("GeneratePrefixedID(%q, 10) = %q, want %s", prefix, id, format)
+			}
+			if seen[id] {
+				t.Fatalf("%q generated twice", id)
+			}
+			seen[id] = true
+		}
+	}
+
+	if id := GeneratePrefixedID("usr", 10); !strings.HasPrefix(id, "usr_") || strings.Count(id, "_") != 1 {
+		t.Errorf("GeneratePrefixedID(%q, 10) = %q", "usr", id)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"time"
-	"math/rand"
 )
 
 var ClientUrl string
@@ -14,15 +13,6 @@ func HashString(s string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-func GenerateRandomString(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	result := make([]byte, length)
-	for i := range result {
-		result[i] = charset[rand.Intn(len(charset))]
-	}
-	return string(result)
-}
-
 func GetCurrentDate() string {
 	return time.Now().Format("2006-01-02 15:04:05")
 }